@@ -16,6 +16,7 @@ package api
 
 import ()
 
+// Runstates, see Runstate for the transitions between them.
 const (
 	RunStateStart = "running"
 	RunStateStop  = "stopped"
@@ -42,6 +43,17 @@ func isOkStatus(code int) bool {
 	return codes[code]
 }
 
-func isBusy(code int) bool {
-	return code == 423
-}
+/*
+ Pointer to the given string, for optional fields in update requests.
+*/
+func String(s string) *string { return &s }
+
+/*
+ Pointer to the given bool, for optional fields in update requests.
+*/
+func Bool(b bool) *bool { return &b }
+
+/*
+ Pointer to the given int, for optional fields in update requests.
+*/
+func Int(i int) *int { return &i }
@@ -24,7 +24,6 @@ import (
 	"io/ioutil"
 	"os"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -36,11 +35,6 @@ type testConfig struct {
 	VpnId      string `json:"vpnId"`
 }
 
-func init() {
-	log.SetLevel(log.DebugLevel)
-	log.SetFormatter(&log.TextFormatter{ForceColors: true})
-}
-
 func skytapClient(t *testing.T) SkytapClient {
 	c := getTestConfig(t)
 	fmt.Printf("c: %s, user: %s", c, c.Username)
@@ -62,16 +56,17 @@ func getTestConfig(t *testing.T) *testConfig {
 	return c
 }
 
-func getMockServer(client SkytapClient) *httptest.Server {
+func getMockServer(client *SkytapClient) *httptest.Server {
 	return getMockServerForString(client, "")
 }
 
-func getMockServerForString(client SkytapClient, content string) *httptest.Server {
+func getMockServerForString(client *SkytapClient, content string) *httptest.Server {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, string(content))
 	}))
 	// Divert all API requests to this server
-	baseUrlOveride = server.URL
+	client.BaseUrlV1 = server.URL
+	client.BaseUrlV2 = server.URL
 	client.HttpClient = server.Client()
 	return server
 }
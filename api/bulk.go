@@ -0,0 +1,172 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ Number of VMs changed at once by the bulk runstate functions when no concurrency is given.
+*/
+const DefaultBulkConcurrency = 5
+
+/*
+ Outcome of changing the runstate of one VM in a bulk change.
+*/
+type RunstateResult struct {
+	// Last representation of the VM fetched, nil if it couldn't be.
+	Vm *VirtualMachine
+	// Why the VM's change failed, nil if it succeeded.
+	Err error
+}
+
+/*
+ Error for the VMs of a bulk runstate change that failed.
+*/
+type BulkRunstateError struct {
+	// Runstate that was requested.
+	Runstate string
+	// Number of VMs in the change.
+	Total int
+	// Errors by VM id.
+	Failed map[string]error
+}
+
+func (e *BulkRunstateError) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for id := range e.Failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	reasons := make([]string, len(ids))
+	for i, id := range ids {
+		reasons[i] = fmt.Sprintf("VM %s: %s", id, e.Failed[id])
+	}
+	return fmt.Sprintf("Unable to change %d of %d VMs to %s, %s", len(e.Failed), e.Total, e.Runstate, strings.Join(reasons, "; "))
+}
+
+/*
+ Changes the runstate of many VMs, possibly in different environments, as the VM methods for the runstate do (Start,
+ Stop, Suspend, Kill and Reset). At most concurrency VMs are changed at once, DefaultBulkConcurrency if it's zero or
+ less; requests still go through the client's rate limiter and retry policy.
+
+ Every VM is attempted, failures don't stop the others. Results are keyed by VM id, and if any VM failed a
+ *BulkRunstateError is also returned. A nil VM, a VM without an id or the same VM given twice is an error, and then no
+ VM is changed.
+*/
+func ChangeVirtualMachineRunstates(client SkytapClient, vms []*VirtualMachine, runstate string, concurrency int) (map[string]*RunstateResult, error) {
+	ids := make([]string, len(vms))
+	for i, vm := range vms {
+		if vm == nil {
+			return nil, fmt.Errorf("VM at index %d is nil", i)
+		}
+		ids[i] = vm.Id
+	}
+	return changeRunstates(client, ids, runstate, concurrency, func(i int) (*VirtualMachine, error) {
+		return vms[i], nil
+	})
+}
+
+/*
+ Changes the runstate of many VMs given by id. See ChangeVirtualMachineRunstates.
+*/
+func ChangeVirtualMachineRunstatesById(client SkytapClient, vmIds []string, runstate string, concurrency int) (map[string]*RunstateResult, error) {
+	return changeRunstates(client, vmIds, runstate, concurrency, func(i int) (*VirtualMachine, error) {
+		return GetVirtualMachine(client, vmIds[i])
+	})
+}
+
+func changeRunstates(client SkytapClient, ids []string, runstate string, concurrency int, getVm func(i int) (*VirtualMachine, error)) (map[string]*RunstateResult, error) {
+	change, ok := runstateChanges[runstate]
+	if !ok {
+		return nil, fmt.Errorf("Unknown runstate %s", runstate)
+	}
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		if id == "" {
+			return nil, fmt.Errorf("VM at index %d has no id", i)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("VM %s is given more than once", id)
+		}
+		seen[id] = true
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+	client.logger().Info("Changing runstate of VMs", "changeState", runstate, "vmIds", ids, "concurrency", concurrency)
+
+	results := make([]*RunstateResult, len(ids))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = changeOneRunstate(client, i, change, getVm)
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	byId := make(map[string]*RunstateResult, len(ids))
+	failed := map[string]error{}
+	for i, id := range ids {
+		byId[id] = results[i]
+		if results[i].Err != nil {
+			failed[id] = results[i].Err
+		}
+	}
+	if len(failed) > 0 {
+		return byId, &BulkRunstateError{Runstate: runstate, Total: len(ids), Failed: failed}
+	}
+	return byId, nil
+}
+
+func changeOneRunstate(client SkytapClient, i int, change func(*VirtualMachine, SkytapClient) (*VirtualMachine, error), getVm func(i int) (*VirtualMachine, error)) *RunstateResult {
+	if err := client.Context().Err(); err != nil {
+		return &RunstateResult{Err: err}
+	}
+	vm, err := getVm(i)
+	if err != nil {
+		return &RunstateResult{Err: err}
+	}
+	changed, err := change(vm, client)
+	if changed != nil {
+		vm = changed
+	}
+	return &RunstateResult{Vm: vm, Err: err}
+}
+
+/*
+ The VM method used for each runstate that can be requested in bulk.
+*/
+var runstateChanges = map[string]func(*VirtualMachine, SkytapClient) (*VirtualMachine, error){
+	RunStateStart: (*VirtualMachine).Start,
+	RunStateStop:  (*VirtualMachine).Stop,
+	RunStatePause: (*VirtualMachine).Suspend,
+	RunStateKill:  (*VirtualMachine).Kill,
+	RunStateReset: (*VirtualMachine).Reset,
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+/*
+ Serves stopped VMs which start as soon as asked, recording how many requests are in flight at once.
+*/
+type bulkVmHandler struct {
+	sync.Mutex
+	vmJson   string
+	missing  string
+	started  map[string]bool
+	inFlight int
+	peak     int
+}
+
+func (h *bulkVmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	h.inFlight++
+	if h.inFlight > h.peak {
+		h.peak = h.inFlight
+	}
+	h.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+
+	h.Lock()
+	defer h.Unlock()
+	h.inFlight--
+
+	id := strings.TrimPrefix(r.URL.Path, "/vms/")
+	if id == h.missing {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": "VM %s not found"}`, id)
+		return
+	}
+	if r.Method == "PUT" {
+		h.started[id] = true
+	}
+	vmJson := strings.Replace(h.vmJson, `"id": "1001"`, `"id": "`+id+`"`, 1)
+	if h.started[id] {
+		vmJson = strings.Replace(vmJson, "stopped", "running", 1)
+	}
+	fmt.Fprintln(w, vmJson)
+}
+
+func TestChangeVirtualMachineRunstates(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = NoRetryPolicy{}
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &bulkVmHandler{vmJson: readJson(t, "testdata/vm-1001.json"), missing: "2005", started: map[string]bool{}}
+	server.Config.Handler = handler
+
+	var ids []string
+	for i := 0; i < 12; i++ {
+		ids = append(ids, fmt.Sprintf("%d", 2000+i))
+	}
+	results, err := ChangeVirtualMachineRunstatesById(client, ids, RunStateStart, 3)
+
+	require.Error(t, err)
+	bulkErr, ok := err.(*BulkRunstateError)
+	require.True(t, ok)
+	require.Equal(t, 12, bulkErr.Total)
+	require.Equal(t, 1, len(bulkErr.Failed))
+	require.True(t, IsNotFound(bulkErr.Failed["2005"]))
+
+	require.Equal(t, 12, len(results), "Every VM should be attempted")
+	for _, id := range ids {
+		if id == "2005" {
+			require.Nil(t, results[id].Vm)
+			continue
+		}
+		require.NoError(t, results[id].Err)
+		require.Equal(t, RunStateStart, results[id].Vm.Runstate)
+		require.Equal(t, id, results[id].Vm.Id)
+	}
+	require.True(t, handler.peak <= 3, "At most 3 VMs should be changed at once, saw %d requests", handler.peak)
+}
+
+func TestChangeVirtualMachineRunstatesUnknown(t *testing.T) {
+	client := skytapClient(t)
+	_, err := ChangeVirtualMachineRunstates(client, []*VirtualMachine{{Id: "1001"}}, "sleeping", 0)
+	require.EqualError(t, err, "Unknown runstate sleeping")
+}
+
+func TestChangeVirtualMachineRunstatesInvalidVms(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+	})
+
+	_, err := ChangeVirtualMachineRunstates(client, []*VirtualMachine{{Id: "1001"}, nil}, RunStateStart, 0)
+	require.EqualError(t, err, "VM at index 1 is nil")
+	_, err = ChangeVirtualMachineRunstates(client, []*VirtualMachine{{Id: "1001"}, {}}, RunStateStart, 0)
+	require.EqualError(t, err, "VM at index 1 has no id")
+	_, err = ChangeVirtualMachineRunstatesById(client, []string{"1001", "1002", "1001"}, RunStateStart, 0)
+	require.EqualError(t, err, "VM 1001 is given more than once")
+}
+
+func TestBulkRunstateError(t *testing.T) {
+	err := &BulkRunstateError{Runstate: RunStateStop, Total: 3, Failed: map[string]error{
+		"2": fmt.Errorf("busy"),
+		"1": fmt.Errorf("not found"),
+	}}
+	require.Equal(t, "Unable to change 2 of 3 VMs to stopped, VM 1: not found; VM 2: busy", err.Error())
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EnvUsername = "SKYTAP_USERNAME"
+	EnvApiToken = "SKYTAP_API_TOKEN"
+	EnvProfile  = "SKYTAP_PROFILE"
+
+	DefaultProfile = "default"
+)
+
+/*
+ Source of skytap API credentials.
+*/
+type CredentialsProvider interface {
+	// Returns the credentials, or an error if this provider has none.
+	Retrieve() (SkytapCredentials, error)
+	// Short description of where the provider looks, used in error messages.
+	String() string
+}
+
+/*
+ Provides a fixed set of credentials.
+*/
+type StaticCredentialsProvider struct {
+	Credentials SkytapCredentials
+}
+
+func (p *StaticCredentialsProvider) Retrieve() (SkytapCredentials, error) {
+	if p.Credentials.Username == "" || p.Credentials.ApiKey == "" {
+		return SkytapCredentials{}, fmt.Errorf("static credentials are incomplete")
+	}
+	return p.Credentials, nil
+}
+
+func (p *StaticCredentialsProvider) String() string { return "static credentials" }
+
+/*
+ Reads credentials from the SKYTAP_USERNAME and SKYTAP_API_TOKEN environment variables.
+*/
+type EnvCredentialsProvider struct{}
+
+func (p *EnvCredentialsProvider) Retrieve() (SkytapCredentials, error) {
+	username := os.Getenv(EnvUsername)
+	apiKey := os.Getenv(EnvApiToken)
+	if username == "" || apiKey == "" {
+		return SkytapCredentials{}, fmt.Errorf("%s and %s must both be set", EnvUsername, EnvApiToken)
+	}
+	return SkytapCredentials{Username: username, ApiKey: apiKey}, nil
+}
+
+func (p *EnvCredentialsProvider) String() string {
+	return fmt.Sprintf("environment variables %s/%s", EnvUsername, EnvApiToken)
+}
+
+/*
+ Reads credentials for a named profile from a JSON config file, by default ~/.skytap/config:
+
+	{
+	  "default": {"username": "me@example.com", "apiKey": "..."},
+	  "staging": {"username": "ci@example.com", "apiKey": "..."}
+	}
+*/
+type FileCredentialsProvider struct {
+	// Path of the config file, if empty ~/.skytap/config is used.
+	Filename string
+	// Profile to read, if empty SKYTAP_PROFILE is used, falling back to "default".
+	Profile string
+}
+
+type profileConfig struct {
+	Username string `json:"username"`
+	ApiKey   string `json:"apiKey"`
+}
+
+func (p *FileCredentialsProvider) Retrieve() (SkytapCredentials, error) {
+	filename, err := p.filename()
+	if err != nil {
+		return SkytapCredentials{}, err
+	}
+
+	configFile, err := os.Open(filename)
+	if err != nil {
+		return SkytapCredentials{}, err
+	}
+	defer configFile.Close()
+
+	profiles := map[string]profileConfig{}
+	if err = json.NewDecoder(configFile).Decode(&profiles); err != nil {
+		return SkytapCredentials{}, fmt.Errorf("parsing %s: %s", filename, err)
+	}
+
+	profile, ok := profiles[p.profile()]
+	if !ok {
+		return SkytapCredentials{}, fmt.Errorf("profile %q not found in %s", p.profile(), filename)
+	}
+	if profile.Username == "" || profile.ApiKey == "" {
+		return SkytapCredentials{}, fmt.Errorf("profile %q in %s needs both username and apiKey", p.profile(), filename)
+	}
+	return SkytapCredentials{Username: profile.Username, ApiKey: profile.ApiKey}, nil
+}
+
+func (p *FileCredentialsProvider) String() string {
+	filename, err := p.filename()
+	if err != nil {
+		filename = "~/.skytap/config"
+	}
+	return fmt.Sprintf("profile %q in %s", p.profile(), filename)
+}
+
+func (p *FileCredentialsProvider) filename() (string, error) {
+	if p.Filename != "" {
+		return p.Filename, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".skytap", "config"), nil
+}
+
+func (p *FileCredentialsProvider) profile() string {
+	if p.Profile != "" {
+		return p.Profile
+	}
+	if profile := os.Getenv(EnvProfile); profile != "" {
+		return profile
+	}
+	return DefaultProfile
+}
+
+/*
+ Tries each provider in turn, returning the first credentials found.
+*/
+type ChainCredentialsProvider struct {
+	Providers []CredentialsProvider
+}
+
+/*
+ Error returned when no provider in a chain has credentials, listing what was tried.
+*/
+type NoCredentialsError struct {
+	Tried  []string
+	Errors []error
+}
+
+func (e *NoCredentialsError) Error() string {
+	reasons := make([]string, len(e.Tried))
+	for i := range e.Tried {
+		reasons[i] = fmt.Sprintf("%s: %s", e.Tried[i], e.Errors[i])
+	}
+	return "no skytap credentials found, tried " + strings.Join(reasons, "; ")
+}
+
+func (p *ChainCredentialsProvider) Retrieve() (SkytapCredentials, error) {
+	noCreds := &NoCredentialsError{}
+	for _, provider := range p.Providers {
+		credentials, err := provider.Retrieve()
+		if err == nil {
+			return credentials, nil
+		}
+		noCreds.Tried = append(noCreds.Tried, provider.String())
+		noCreds.Errors = append(noCreds.Errors, err)
+	}
+	return SkytapCredentials{}, noCreds
+}
+
+func (p *ChainCredentialsProvider) String() string {
+	names := make([]string, len(p.Providers))
+	for i, provider := range p.Providers {
+		names[i] = provider.String()
+	}
+	return "chain of " + strings.Join(names, ", ")
+}
+
+/*
+ Create the default chain: environment variables first, then the default config file.
+*/
+func NewDefaultCredentialsChain() *ChainCredentialsProvider {
+	return &ChainCredentialsProvider{Providers: []CredentialsProvider{
+		&EnvCredentialsProvider{},
+		&FileCredentialsProvider{},
+	}}
+}
+
+/*
+ Create a new client with credentials resolved from the given provider, with additional options.
+*/
+func NewSkytapClientFromProvider(provider CredentialsProvider, opts ...ClientOption) (*SkytapClient, error) {
+	credentials, err := provider.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	return NewSkytapClientFromCredentials(credentials, opts...), nil
+}
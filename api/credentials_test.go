@@ -0,0 +1,105 @@
+package api
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCredentialsFile(t *testing.T) string {
+	filename := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(filename, []byte(`{
+  "default": {"username": "default-user", "apiKey": "default-key"},
+  "staging": {"username": "staging-user", "apiKey": "staging-key"},
+  "broken": {"username": "no-key"}
+}`), 0600)
+	require.NoError(t, err)
+	return filename
+}
+
+func TestEnvCredentialsProvider(t *testing.T) {
+	t.Setenv(EnvUsername, "env-user")
+	t.Setenv(EnvApiToken, "")
+
+	_, err := (&EnvCredentialsProvider{}).Retrieve()
+	require.Error(t, err, "Should require both variables")
+
+	t.Setenv(EnvApiToken, "env-key")
+	creds, err := (&EnvCredentialsProvider{}).Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, SkytapCredentials{"env-user", "env-key"}, creds)
+}
+
+func TestFileCredentialsProvider(t *testing.T) {
+	filename := writeCredentialsFile(t)
+	t.Setenv(EnvProfile, "")
+
+	creds, err := (&FileCredentialsProvider{Filename: filename}).Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, SkytapCredentials{"default-user", "default-key"}, creds)
+
+	creds, err = (&FileCredentialsProvider{Filename: filename, Profile: "staging"}).Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, SkytapCredentials{"staging-user", "staging-key"}, creds)
+
+	t.Setenv(EnvProfile, "staging")
+	creds, err = (&FileCredentialsProvider{Filename: filename}).Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, "staging-user", creds.Username, "Should use SKYTAP_PROFILE")
+
+	_, err = (&FileCredentialsProvider{Filename: filename, Profile: "broken"}).Retrieve()
+	require.Error(t, err)
+
+	_, err = (&FileCredentialsProvider{Filename: filename, Profile: "missing"}).Retrieve()
+	require.Contains(t, err.Error(), `profile "missing" not found`)
+}
+
+func TestChainCredentialsProvider(t *testing.T) {
+	filename := writeCredentialsFile(t)
+	t.Setenv(EnvUsername, "")
+	t.Setenv(EnvApiToken, "")
+	t.Setenv(EnvProfile, "")
+
+	chain := &ChainCredentialsProvider{Providers: []CredentialsProvider{
+		&EnvCredentialsProvider{},
+		&FileCredentialsProvider{Filename: filename},
+	}}
+	creds, err := chain.Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, "default-user", creds.Username, "Should fall through to the config file")
+
+	t.Setenv(EnvUsername, "env-user")
+	t.Setenv(EnvApiToken, "env-key")
+	creds, err = chain.Retrieve()
+	require.NoError(t, err)
+	require.Equal(t, "env-user", creds.Username, "Environment should take precedence")
+
+	client, err := NewSkytapClientFromProvider(chain, WithUserAgent("test"))
+	require.NoError(t, err)
+	require.Equal(t, SkytapCredentials{"env-user", "env-key"}, client.Credentials)
+}
+
+func TestChainCredentialsProviderNoneFound(t *testing.T) {
+	t.Setenv(EnvUsername, "")
+	t.Setenv(EnvApiToken, "")
+
+	chain := &ChainCredentialsProvider{Providers: []CredentialsProvider{
+		&StaticCredentialsProvider{},
+		&EnvCredentialsProvider{},
+		&FileCredentialsProvider{Filename: filepath.Join(t.TempDir(), "missing"), Profile: "ci"},
+	}}
+	_, err := chain.Retrieve()
+	require.Error(t, err)
+
+	noCreds, ok := err.(*NoCredentialsError)
+	require.True(t, ok, "Should be a *NoCredentialsError")
+	require.Equal(t, 3, len(noCreds.Tried))
+	require.Contains(t, err.Error(), "static credentials")
+	require.Contains(t, err.Error(), "environment variables SKYTAP_USERNAME/SKYTAP_API_TOKEN")
+	require.Contains(t, err.Error(), `profile "ci" in`)
+
+	_, err = NewSkytapClientFromProvider(chain)
+	require.Error(t, err)
+}
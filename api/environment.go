@@ -15,9 +15,13 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/dghubble/sling"
 )
 
@@ -37,6 +41,125 @@ type Environment struct {
 	Runstate    string            `json:"runstate,omitempty"`
 	Vms         []*VirtualMachine `json:"vms,omitempty"`
 	Networks    []Network         `json:"networks,omitempty"`
+	Region      string            `json:"region,omitempty"`
+	OwnerName   string            `json:"owner_name,omitempty"`
+	OwnerUrl    string            `json:"owner_url,omitempty"`
+	VmCount     int               `json:"vm_count,omitempty"`
+	// URL of the user owning the environment.
+	Owner           string `json:"owner,omitempty"`
+	LockVersion     string `json:"lockversion,omitempty"`
+	Routable        bool   `json:"routable,omitempty"`
+	DisableInternet bool   `json:"disable_internet,omitempty"`
+	// Seconds of inactivity after which the environment is suspended, nil if it isn't suspended when idle. See
+	// SuspendOnIdleDuration.
+	SuspendOnIdle *int `json:"suspend_on_idle,omitempty"`
+	// When the environment will be suspended, in SuspendAtTimeLayout; nil if it isn't scheduled to be. See SuspendAt.
+	SuspendAtTime *string `json:"suspend_at_time,omitempty"`
+	// Like SuspendOnIdle and SuspendAtTime, but for shutting the environment down.
+	ShutdownOnIdle *int    `json:"shutdown_on_idle,omitempty"`
+	ShutdownAtTime *string `json:"shutdown_at_time,omitempty"`
+}
+
+/*
+ Changes to an environment's settings for UpdateEnvironment. Only fields that are set are changed; nil fields are left
+ as they are. For SuspendOnIdle and SuspendAtTime a set zero value, Int(0) or String(""), clears the setting.
+*/
+type EnvironmentUpdate struct {
+	Name        *string
+	Description *string
+	// Id of the user to transfer the environment to.
+	Owner *string
+	// Seconds of inactivity after which to suspend the environment, Int(0) turns suspending when idle off. See
+	// SetSuspendOnIdle.
+	SuspendOnIdle *int
+	// When to suspend the environment, in SuspendAtTimeLayout; String("") turns scheduled suspending off. See
+	// SetSuspendAt.
+	SuspendAtTime *string
+	// Whether the environment's networks may be routed to networks of other environments.
+	Routable *bool
+	// Set to block outbound internet traffic from the environment's VMs.
+	DisableInternet *bool
+}
+
+/*
+ Only set fields are sent. A zero SuspendOnIdle or SuspendAtTime means clear the setting, and is sent as null as skytap
+ expects, since skytap doesn't accept 0 or an empty time.
+*/
+func (u EnvironmentUpdate) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{}
+	if u.Name != nil {
+		body["name"] = *u.Name
+	}
+	if u.Description != nil {
+		body["description"] = *u.Description
+	}
+	if u.Owner != nil {
+		body["owner"] = *u.Owner
+	}
+	if u.SuspendOnIdle != nil {
+		body["suspend_on_idle"] = nil
+		if *u.SuspendOnIdle != 0 {
+			body["suspend_on_idle"] = *u.SuspendOnIdle
+		}
+	}
+	if u.SuspendAtTime != nil {
+		body["suspend_at_time"] = nil
+		if *u.SuspendAtTime != "" {
+			body["suspend_at_time"] = *u.SuspendAtTime
+		}
+	}
+	if u.Routable != nil {
+		body["routable"] = *u.Routable
+	}
+	if u.DisableInternet != nil {
+		body["disable_internet"] = *u.DisableInternet
+	}
+	return json.Marshal(body)
+}
+
+/*
+ Criteria for ListEnvironments, empty fields are not filtered on. Values must not contain commas, and only Label may
+ contain a colon.
+*/
+type EnvironmentFilter struct {
+	// Environments whose name contains this text.
+	Name      string
+	Region    string
+	OwnerId   string
+	ProjectId string
+	Runstate  string
+	// A "category:value" label.
+	Label string
+	// ScopeMe for the caller's own environments (the API default) or ScopeCompany for every environment visible to the
+	// caller.
+	Scope string
+}
+
+type environmentListQuery struct {
+	Scope string `url:"scope,omitempty"`
+	Query string `url:"query,omitempty"`
+}
+
+/*
+ The filter as skytap list query parameters, where the criteria are joined into a single "query" parameter.
+*/
+func (f *EnvironmentFilter) listQuery() (*environmentListQuery, error) {
+	query := &environmentListQuery{}
+	if f == nil {
+		return query, nil
+	}
+	query.Scope = f.Scope
+
+	var err error
+	query.Query, err = listQuery(
+		listQueryTerm{key: "name", value: f.Name},
+		listQueryTerm{key: "region", value: f.Region},
+		listQueryTerm{key: "owner_id", value: f.OwnerId},
+		listQueryTerm{key: "project_id", value: f.ProjectId},
+		listQueryTerm{key: "runstate", value: f.Runstate},
+		listQueryTerm{key: "label", value: f.Label, allowColon: true},
+	)
+	return query, err
 }
 
 /*
@@ -73,23 +196,50 @@ type MergeEnvironmentBody struct {
 func environmentIdV1Path(envId string) string { return EnvironmentPath + "/" + envId }
 func environmentIdPath(envId string) string   { return EnvironmentPath + "/" + envId + ".json" }
 
-func RenameEnvironment(client SkytapClient, envId string, name string, restartEnv bool) (*Environment, error) {
-	nameReq := func(s *sling.Sling) *sling.Sling {
-		return s.Put(environmentIdPath(envId)).BodyJSON(&Environment{Name: name})
+/*
+ Change an environment's settings, see EnvironmentUpdate.
+*/
+func UpdateEnvironment(client SkytapClient, envId string, update *EnvironmentUpdate) (*Environment, error) {
+	return updateEnvironment(client, true, envId, update)
+}
+
+func updateEnvironment(client SkytapClient, useV2 bool, envId string, update *EnvironmentUpdate) (*Environment, error) {
+	client.logger().Info("Updating environment", "envId", envId)
+
+	updateEnv := func(s *sling.Sling) *sling.Sling {
+		return s.Put(environmentIdPath(envId)).BodyJSON(update)
 	}
 
-	interfaceResp := &Environment{}
+	env := &Environment{}
+	_, err := RunSkytapRequest(client, useV2, env, updateEnv)
+	return env, err
+}
+
+/*
+ Rename an environment.
+
+ restartEnv is deprecated and ignored: renaming doesn't stop the environment, so there is nothing to restart. It is
+ kept so that existing callers still compile.
+*/
+func RenameEnvironment(client SkytapClient, envId string, name string, restartEnv bool) (*Environment, error) {
+	client.logger().Info("Renaming environment", "newName", name, "envId", envId)
+
+	// Renames have always gone through the V1 API, unlike UpdateEnvironment.
+	return updateEnvironment(client, false, envId, &EnvironmentUpdate{Name: String(name)})
+}
 
-	log.WithFields(log.Fields{"newName": name, "envId": envId}).Infof("Renaming environment")
-	_, err := RunSkytapRequest(client, false, interfaceResp, nameReq)
-	return interfaceResp, err
+/*
+ Change this environment's settings, see EnvironmentUpdate.
+*/
+func (e *Environment) Update(client SkytapClient, update *EnvironmentUpdate) (*Environment, error) {
+	return UpdateEnvironment(client, e.Id, update)
 }
 
 /*
  Adds a VM to an existing environment.
 */
 func (e *Environment) AddVirtualMachine(client SkytapClient, vmId string) (*Environment, error) {
-	log.WithFields(log.Fields{"vmId": vmId, "envId": e.Id}).Info("Adding virtual machine")
+	client.logger().Info("Adding virtual machine", "vmId", vmId, "envId", e.Id)
 
 	vm, err := GetVirtualMachine(client, vmId)
 	if err != nil {
@@ -131,6 +281,13 @@ func (e *Environment) WaitUntilReady(client SkytapClient) (*Environment, error)
 	return e.WaitUntilInState(client, []string{RunStateStop, RunStateStart, RunStatePause}, false)
 }
 
+/*
+ WaitUntilReady, polling as opts say instead of the client's WaitOptions. If opts is nil the client's are used.
+*/
+func (e *Environment) WaitUntilReadyWithOptions(client SkytapClient, opts *WaitOptions) (*Environment, error) {
+	return e.WaitUntilReady(client.withCallWaitOptions(opts))
+}
+
 /*
  Merge an environment based VM into this environment (the VM must be in an existing environment).
 */
@@ -152,7 +309,7 @@ func (e *Environment) MergeTemplateVirtualMachine(client SkytapClient, templateI
 */
 func (e *Environment) MergeVirtualMachine(client SkytapClient, mergeBody interface{}) (*Environment, error) {
 
-	log.WithFields(log.Fields{"mergeBody": mergeBody, "envId": e.Id}).Info("Merging a VM into environment")
+	client.logger().Info("Merging a VM into environment", "mergeBody", mergeBody, "envId", e.Id)
 
 	merge := func(s *sling.Sling) *sling.Sling {
 		return s.Put(environmentIdPath(e.Id)).BodyJSON(mergeBody)
@@ -161,40 +318,277 @@ func (e *Environment) MergeVirtualMachine(client SkytapClient, mergeBody interfa
 	newEnv := &Environment{}
 	_, err := RunSkytapRequest(client, false, newEnv, merge)
 	if err != nil {
-		log.Errorf("Unable to add VM to environment (%s), requestBody: %+v, cause: %s", e.Id, mergeBody, err)
+		client.logger().Error("Unable to add VM to environment", "envId", e.Id, "requestBody", mergeBody, "error", err)
 		return e, err
 	}
 	return newEnv, nil
 }
 
+/*
+ Save the environment as a new template, and wait until the template is ready. The template is polled as the client's
+ WaitOptions say; if it is still busy when they time out, a *WaitTimeoutError is returned and it isn't renamed.
+
+ vmIds - VMs to include, which must be part of the environment. If empty all VMs are included.
+ name, description - Set on the new template if not empty, otherwise the defaults given by skytap are kept.
+*/
+func (e *Environment) SaveAsTemplate(client SkytapClient, vmIds []string, name string, description string) (*Template, error) {
+	client.logger().Info("Saving environment as template", "envId", e.Id, "vmIds", vmIds)
+
+	createTemplate := func(s *sling.Sling) *sling.Sling {
+		return s.Post(TemplatePath + ".json").BodyJSON(&CreateTemplateBody{EnvironmentId: e.Id, VmIds: vmIds})
+	}
+
+	template := &Template{}
+	_, err := RunSkytapRequest(client, true, template, createTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err = template.WaitUntilReady(client)
+	if err != nil || (name == "" && description == "") {
+		return template, err
+	}
+
+	update := &TemplateUpdate{}
+	if name != "" {
+		update.Name = String(name)
+	}
+	if description != "" {
+		update.Description = String(description)
+	}
+	named, err := UpdateTemplate(client, template.Id, update)
+	if err != nil {
+		return template, err
+	}
+	return named, nil
+}
+
 /*
  Starts an environment.
 */
 func (e *Environment) Start(client SkytapClient) (*Environment, error) {
-	log.WithFields(log.Fields{"envId": e.Id}).Info("Starting Environment")
+	client.logger().Info("Starting Environment", "envId", e.Id)
 
 	return e.ChangeRunstate(client, RunStateStart, RunStateStart)
 }
 
+/*
+ Starts an environment in the background. See Start.
+*/
+func (e *Environment) StartAsync(client SkytapClient) *Operation[*Environment] {
+	return StartOperation(client, e.Start)
+}
+
 /*
  Suspends an environment.
 */
 func (e *Environment) Suspend(client SkytapClient) (*Environment, error) {
-	log.WithFields(log.Fields{"envId": e.Id}).Info("Stopping Environment")
+	client.logger().Info("Stopping Environment", "envId", e.Id)
 
 	return e.ChangeRunstate(client, RunStatePause, RunStatePause)
 }
 
+/*
+ Shuts down an environment's VMs gracefully.
+
+ VMs without VMware tools loaded can't be shut down this way and are left running, in which case the environment is
+ returned along with a *VmRunstateError listing them. Use Kill to power them off.
+*/
+func (e *Environment) Stop(client SkytapClient) (*Environment, error) {
+	client.logger().Info("Stopping Environment", "envId", e.Id)
+
+	if err := e.checkNotSuspended(client, "stop"); err != nil {
+		return e, err
+	}
+	return e.changeVmRunstates(client, RunStateStop, RunStateStop)
+}
+
+/*
+ Powers off an environment's VMs, without shutting down their guest OS.
+*/
+func (e *Environment) Kill(client SkytapClient) (*Environment, error) {
+	client.logger().Info("Killing Environment", "envId", e.Id)
+
+	if err := e.checkNotSuspended(client, "kill"); err != nil {
+		return e, err
+	}
+	return e.changeVmRunstates(client, RunStateKill, RunStateStop)
+}
+
+/*
+ Power cycles an environment's VMs, leaving them running.
+*/
+func (e *Environment) Reset(client SkytapClient) (*Environment, error) {
+	client.logger().Info("Resetting Environment", "envId", e.Id)
+
+	return e.changeVmRunstates(client, RunStateReset, RunStateStart)
+}
+
+/*
+ Error for VMs of an environment that didn't reach the runstate requested for the whole environment.
+*/
+type VmRunstateError struct {
+	EnvironmentId string
+	// Runstate that was requested.
+	Runstate string
+	// Reasons by VM id, the VM's error message if skytap reported one, otherwise its runstate.
+	Vms map[string]string
+}
+
+func (e *VmRunstateError) Error() string {
+	ids := make([]string, 0, len(e.Vms))
+	for id := range e.Vms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	reasons := make([]string, len(ids))
+	for i, id := range ids {
+		reasons[i] = fmt.Sprintf("VM %s: %s", id, e.Vms[id])
+	}
+	return fmt.Sprintf("Unable to change environment %s to %s, %s", e.EnvironmentId, e.Runstate, strings.Join(reasons, "; "))
+}
+
+/*
+ Refuses stopping an environment with suspended VMs, which skytap rejects.
+*/
+func (e *Environment) checkNotSuspended(client SkytapClient, action string) error {
+	current, err := GetEnvironment(client, e.Id)
+	if err != nil {
+		return err
+	}
+	if current.Runstate == RunStatePause {
+		return fmt.Errorf("Unable to %s a suspended environment.", action)
+	}
+	for _, vm := range current.Vms {
+		if vm.Runstate == RunStatePause {
+			return fmt.Errorf("Unable to %s environment %s, VM %s is suspended.", action, e.Id, vm.Id)
+		}
+	}
+	return nil
+}
+
+/*
+ Requests a runstate for all of the environment's VMs, waits for the change to finish, and reports VMs that didn't end
+ up in desiredRunstate.
+
+ Skytap accepts the change even if some VMs can't make it, so the environment as a whole may never reach
+ desiredRunstate; waiting until it's no longer busy avoids waiting for a state it won't get to. Before that, the
+ environment is polled until the change is seen to start, so that its old runstate isn't taken for the result. A reset
+ leaves the VMs running as they were, so as for VirtualMachine.Reset, it may not be seen.
+*/
+func (e *Environment) changeVmRunstates(client SkytapClient, runstate string, desiredRunstate string) (*Environment, error) {
+	ready, err := e.WaitUntilReady(client)
+	if err != nil {
+		return ready, err
+	}
+	if err = CheckRunstateChange(Runstate(ready.Runstate), Runstate(runstate)); err != nil {
+		return ready, err
+	}
+	if runstate != RunStateReset && ready.allInRunstate(string(Runstate(runstate).Result())) {
+		return ready, nil
+	}
+	changeState := func(s *sling.Sling) *sling.Sling {
+		return s.Put(environmentIdPath(e.Id)).BodyJSON(&RunstateBody{Runstate: runstate})
+	}
+	changed := &Environment{}
+	_, err = RunSkytapRequest(client, false, changed, changeState)
+	if err != nil {
+		return e, err
+	}
+
+	if changed.Id == "" {
+		// No representation came back to tell whether the change has started.
+		changed = ready
+	}
+	if runstate != RunStateReset && !ready.changeStarted(changed) {
+		if changed, err = ready.waitForChangeToStart(client, runstate); err != nil {
+			return changed, err
+		}
+	}
+	newEnv, err := changed.WaitUntilReady(client)
+	if err != nil {
+		return newEnv, err
+	}
+	failed := map[string]string{}
+	for _, vm := range newEnv.Vms {
+		if vm.Runstate == desiredRunstate {
+			continue
+		}
+		if vm.Error != nil && vm.Error != false && vm.Error != "" {
+			failed[vm.Id] = fmt.Sprintf("%v", vm.Error)
+		} else {
+			failed[vm.Id] = vm.Runstate
+		}
+	}
+	if len(failed) > 0 {
+		return newEnv, &VmRunstateError{EnvironmentId: e.Id, Runstate: runstate, Vms: failed}
+	}
+	return newEnv, nil
+}
+
+/*
+ Whether current shows a runstate change requested from this representation of the environment to have started: it or
+ one of its VMs is busy, has changed runstate, or has reported an error.
+*/
+func (e *Environment) changeStarted(current *Environment) bool {
+	if current.Runstate == RunStateBusy || current.Runstate != e.Runstate {
+		return true
+	}
+	before := make(map[string]string, len(e.Vms))
+	for _, vm := range e.Vms {
+		before[vm.Id] = vm.Runstate
+	}
+	for _, vm := range current.Vms {
+		if vm.Runstate == RunStateBusy || vm.Runstate != before[vm.Id] || (vm.Error != nil && vm.Error != false && vm.Error != "") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ Polls the environment until a runstate change requested from this representation is seen to start.
+*/
+func (e *Environment) waitForChangeToStart(client SkytapClient, runstate string) (*Environment, error) {
+	start := time.Now()
+
+	current := e
+	err := client.poll(func(client SkytapClient) (bool, error) {
+		refreshed, err := GetEnvironment(client, e.Id)
+		if err != nil {
+			return false, err
+		}
+		current = refreshed
+		return e.changeStarted(current), nil
+	})
+	if err == errWaitTimeout {
+		waited := time.Since(start)
+		err = &WaitTimeoutError{Waited: waited, message: fmt.Sprintf("Environment %s didn't start changing to %s after %d seconds", e.Id, runstate, waited/time.Second)}
+	}
+	return current, err
+}
+
 /*
  Changes the runstate of the Environment to the specified state and waits until the Environment is in the desired state.
+
+ Changes that aren't allowed from the environment's runstate are refused with a *RunstateTransitionError, see
+ CheckRunstateChange. Nothing is requested if the environment and all of its VMs are already in the runstate the change
+ would lead to.
 */
 func (e *Environment) ChangeRunstate(client SkytapClient, runstate string, desiredRunstate string) (*Environment, error) {
-	log.WithFields(log.Fields{"changeState": runstate, "targetState": desiredRunstate, "envId": e.Id}).Info("Changing VM runstate")
+	client.logger().Info("Changing VM runstate", "changeState", runstate, "targetState", desiredRunstate, "envId", e.Id)
 
 	ready, err := e.WaitUntilReady(client)
 	if err != nil {
 		return ready, err
 	}
+	if err = CheckRunstateChange(Runstate(ready.Runstate), Runstate(runstate)); err != nil {
+		return ready, err
+	}
+	if runstate != RunStateReset && ready.allInRunstate(string(Runstate(runstate).Result())) {
+		return ready, nil
+	}
 	changeState := func(s *sling.Sling) *sling.Sling {
 		return s.Put(environmentIdPath(e.Id)).BodyJSON(&RunstateBody{Runstate: runstate})
 	}
@@ -206,6 +600,25 @@ func (e *Environment) ChangeRunstate(client SkytapClient, runstate string, desir
 	return e.WaitUntilInState(client, []string{desiredRunstate}, true)
 }
 
+/*
+ ChangeRunstate, polling as opts say instead of the client's WaitOptions. If opts is nil the client's are used.
+*/
+func (e *Environment) ChangeRunstateWithOptions(client SkytapClient, opts *WaitOptions, runstate string, desiredRunstate string) (*Environment, error) {
+	return e.ChangeRunstate(client.withCallWaitOptions(opts), runstate, desiredRunstate)
+}
+
+func (e *Environment) allInRunstate(runstate string) bool {
+	if e.Runstate != runstate {
+		return false
+	}
+	for _, vm := range e.Vms {
+		if vm.Runstate != runstate {
+			return false
+		}
+	}
+	return true
+}
+
 /*
  Return an existing environment by id.
 */
@@ -220,11 +633,28 @@ func GetEnvironment(client SkytapClient, envId string) (*Environment, error) {
 	return env, err
 }
 
+/*
+ Calls fn with each environment matching the filter, paged as given by opts. A nil filter lists all of the caller's
+ environments.
+
+ Listed environments are summaries, their VMs and networks aren't included; use GetEnvironment for the full resource.
+*/
+func ListEnvironments(client SkytapClient, filter *EnvironmentFilter, opts *ListOptions, fn func(env *Environment) error) error {
+	query, err := filter.listQuery()
+	if err != nil {
+		return err
+	}
+	listEnvs := func(s *sling.Sling) *sling.Sling {
+		return s.Get(EnvironmentPath + ".json").QueryStruct(query)
+	}
+	return ListEach(client, true, opts, listEnvs, fn)
+}
+
 /*
  Create a new environment from a template.
 */
 func CreateNewEnvironment(client SkytapClient, templateId string) (*Environment, error) {
-	log.WithFields(log.Fields{"templateId": templateId}).Info("Creating environment from template")
+	client.logger().Info("Creating environment from template", "templateId", templateId)
 
 	env := &Environment{}
 
@@ -236,11 +666,24 @@ func CreateNewEnvironment(client SkytapClient, templateId string) (*Environment,
 	return env, err
 }
 
+/*
+ Creates a new environment from a template in the background, the operation finishes once the environment is ready.
+*/
+func CreateNewEnvironmentAsync(client SkytapClient, templateId string) *Operation[*Environment] {
+	return StartOperation(client, func(client SkytapClient) (*Environment, error) {
+		env, err := CreateNewEnvironment(client, templateId)
+		if err != nil {
+			return env, err
+		}
+		return env.WaitUntilReady(client)
+	})
+}
+
 /*
  Create a new environment from a source template, including only specific VMs, which must be a part of the template.
 */
 func CreateNewEnvironmentWithVms(client SkytapClient, templateId string, vmIds []string) (*Environment, error) {
-	log.WithFields(log.Fields{"templateId": templateId}).Info("Creating environment from template")
+	client.logger().Info("Creating environment from template", "templateId", templateId)
 
 	env := &Environment{}
 
@@ -256,7 +699,7 @@ func CreateNewEnvironmentWithVms(client SkytapClient, templateId string, vmIds [
  Create a new environment from a source environment, including only specific VMs, which must be a part of the template.
 */
 func CopyEnvironmentWithVms(client SkytapClient, sourceEnvId string, vmIds []string) (*Environment, error) {
-	log.WithFields(log.Fields{"sourceEnvId": sourceEnvId}).Info("Copying environment from existing")
+	client.logger().Info("Copying environment from existing", "sourceEnvId", sourceEnvId)
 
 	env := &Environment{}
 
@@ -272,7 +715,7 @@ func CopyEnvironmentWithVms(client SkytapClient, sourceEnvId string, vmIds []str
  Delete an environment by id.
 */
 func DeleteEnvironment(client SkytapClient, envId string) error {
-	log.WithFields(log.Fields{"envId": envId}).Info("Deleting environment")
+	client.logger().Info("Deleting environment", "envId", envId)
 
 	deleteEnv := func(s *sling.Sling) *sling.Sling {
 		return s.Delete(EnvironmentPath + "/" + envId)
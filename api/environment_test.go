@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -14,7 +15,7 @@ func TestCreateNewEnvironment(t *testing.T) {
 	envJson := readJson(t, "testdata/environment-1.json")
 
 	client := skytapClient(t)
-	server := getMockServer(client)
+	server := getMockServer(&client)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -34,7 +35,7 @@ func TestCreateNewEnvironmentWithVms(t *testing.T) {
 	envJson := readJson(t, "testdata/environment-1.json")
 
 	client := skytapClient(t)
-	server := getMockServer(client)
+	server := getMockServer(&client)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -54,7 +55,7 @@ func TestCopyEnvironmentWithVms(t *testing.T) {
 	envJson := readJson(t, "testdata/environment-1.json")
 
 	client := skytapClient(t)
-	server := getMockServer(client)
+	server := getMockServer(&client)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +77,7 @@ func TestAddVirtualMachineFromTemplate(t *testing.T) {
 	templateVmJson := readJson(t, "testdata/vm-1002.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, envJson)
+	server := getMockServerForString(&client, envJson)
 	defer server.Close()
 
 	env, err := GetEnvironment(client, "1")
@@ -111,7 +112,7 @@ func TestAddVirtualMachineFromEnvironment(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, envJson)
+	server := getMockServerForString(&client, envJson)
 	defer server.Close()
 
 	env, err := GetEnvironment(client, "1")
@@ -140,3 +141,315 @@ func TestAddVirtualMachineFromEnvironment(t *testing.T) {
 	require.NoError(t, err, "Error adding vm from template")
 	require.Equal(t, "Environment 1", env.Name)
 }
+
+func TestListEnvironments(t *testing.T) {
+	listJson := readJson(t, "testdata/environment-list.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method)
+		require.Equal(t, "/configurations.json", r.URL.Path)
+		require.Equal(t, "company", r.URL.Query().Get("scope"))
+		require.Equal(t, "name:Environment,region:US-West,owner_id:7,label:team:infra", r.URL.Query().Get("query"))
+		require.Equal(t, "50", r.URL.Query().Get("count"))
+		fmt.Fprintln(w, listJson)
+	})
+
+	filter := &EnvironmentFilter{Name: "Environment", Region: "US-West", OwnerId: "7", Label: "team:infra", Scope: "company"}
+	var envs []*Environment
+	err := ListEnvironments(client, filter, &ListOptions{PageSize: 50}, func(env *Environment) error {
+		envs = append(envs, env)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(envs))
+	require.Equal(t, "Environment 3", envs[1].Name)
+	require.Equal(t, 4, envs[1].VmCount)
+	require.Equal(t, "Jane Doe", envs[1].OwnerName)
+}
+
+func TestListEnvironmentsUnfiltered(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasQuery := r.URL.Query()["query"]
+		require.False(t, hasQuery, "An empty filter should not send a query")
+		_, hasScope := r.URL.Query()["scope"]
+		require.False(t, hasScope)
+		fmt.Fprintln(w, "[]")
+	})
+
+	calls := 0
+	err := ListEnvironments(client, nil, nil, func(env *Environment) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, calls)
+}
+
+func TestListEnvironmentsInvalidFilter(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	requests := 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintln(w, "[]")
+	})
+
+	for _, filter := range []*EnvironmentFilter{{Name: "web,runstate:running"}, {Region: "US:West"}, {Label: "team:a,b"}} {
+		err := ListEnvironments(client, filter, nil, func(env *Environment) error { return nil })
+		require.Error(t, err, "Filter %+v should be rejected", filter)
+	}
+	require.Equal(t, 0, requests, "Invalid filters should not be sent")
+}
+
+func TestSaveAsTemplate(t *testing.T) {
+	templateJson := readJson(t, "testdata/template-2.json")
+	busyJson := strings.Replace(templateJson, `"busy": null`, `"busy": true`, 1)
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond}
+
+	var requests []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+		if len(requests) <= 3 {
+			fmt.Fprintln(w, busyJson)
+		} else {
+			fmt.Fprintln(w, templateJson)
+		}
+	})
+
+	env := &Environment{Id: "1"}
+	template, err := env.SaveAsTemplate(client, []string{"1001"}, "Golden", "nightly")
+	require.NoError(t, err)
+	require.False(t, template.IsBusy())
+	require.Equal(t, []string{
+		`POST /templates.json {"configuration_id":"1","vm_instance_ids":["1001"]}`,
+		"GET /templates/2.json ",
+		"GET /templates/2.json ",
+		"GET /templates/2.json ",
+		`PUT /templates/2.json {"name":"Golden","description":"nightly"}`,
+	}, requests)
+}
+
+func TestSaveAsTemplateTimeout(t *testing.T) {
+	busyJson := strings.Replace(readJson(t, "testdata/template-2.json"), `"busy": null`, `"busy": true`, 1)
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond, Timeout: 20 * time.Millisecond}
+
+	var methods []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		fmt.Fprintln(w, busyJson)
+	})
+
+	env := &Environment{Id: "1"}
+	template, err := env.SaveAsTemplate(client, nil, "Golden", "")
+	require.True(t, IsWaitTimeout(err), "The client's wait timeout should apply, got %v", err)
+	require.True(t, template.IsBusy())
+	require.NotContains(t, methods, "PUT", "A busy template should not be renamed")
+}
+
+func TestSaveAsTemplateDefaults(t *testing.T) {
+	templateJson := readJson(t, "testdata/template-2.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var requests []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+strings.TrimSpace(string(body)))
+		fmt.Fprintln(w, templateJson)
+	})
+
+	env := &Environment{Id: "1"}
+	template, err := env.SaveAsTemplate(client, nil, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "Template with 2 VMs", template.Name)
+	require.Equal(t, []string{`POST {"configuration_id":"1"}`, "GET "}, requests, "Should not rename without a name or description")
+}
+
+func TestStopWaitsForChangeToStart(t *testing.T) {
+	envJson := readJson(t, "testdata/environment-1.json")
+	runningJson := strings.Replace(envJson, `"runstate": "stopped"`, `"runstate": "running"`, -1)
+	busyJson := strings.Replace(envJson, `"runstate": "stopped"`, `"runstate": "busy"`, -1)
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond, Timeout: time.Second}
+
+	// Skytap can keep showing the old runstate for a while after accepting the change.
+	afterPut := []string{runningJson, runningJson, busyJson, envJson}
+	put := false
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT":
+			put = true
+			fmt.Fprintln(w, runningJson)
+		case !put:
+			fmt.Fprintln(w, runningJson)
+		case len(afterPut) > 1:
+			fmt.Fprintln(w, afterPut[0])
+			afterPut = afterPut[1:]
+		default:
+			fmt.Fprintln(w, afterPut[0])
+		}
+	})
+
+	env, err := (&Environment{Id: "1"}).Stop(client)
+	require.NoError(t, err, "The old runstate should not be taken for the result")
+	require.Equal(t, RunStateStop, env.Runstate)
+	for _, vm := range env.Vms {
+		require.Equal(t, RunStateStop, vm.Runstate)
+	}
+}
+
+func TestStopSuspendedEnvironment(t *testing.T) {
+	envJson := readJson(t, "testdata/environment-1.json")
+	envJson = strings.Replace(envJson, `"runstate": "stopped"`, `"runstate": "suspended"`, 1)
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var methods []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		fmt.Fprintln(w, envJson)
+	})
+
+	env := &Environment{Id: "1"}
+	_, err := env.Stop(client)
+	require.EqualError(t, err, "Unable to stop a suspended environment.")
+	_, err = env.Kill(client)
+	require.Error(t, err)
+	_, err = env.Reset(client)
+	require.Error(t, err)
+	require.Equal(t, []string{"GET", "GET", "GET"}, methods, "No runstate change should be requested")
+}
+
+func TestChangeRunstateAlreadyInState(t *testing.T) {
+	envJson := readJson(t, "testdata/environment-1.json")
+	runningJson := strings.Replace(envJson, `"runstate": "stopped"`, `"runstate": "running"`, -1)
+	// The environment is running, but one of its VMs isn't.
+	mixedJson := strings.Replace(envJson, `"runstate": "stopped"`, `"runstate": "running"`, 1)
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond, Timeout: time.Second}
+
+	var methods []string
+	served := runningJson
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == "PUT" {
+			served = runningJson
+		}
+		fmt.Fprintln(w, served)
+	})
+
+	env, err := (&Environment{Id: "1", Runstate: RunStateStart}).Start(client)
+	require.NoError(t, err, "Starting a running environment should not wait for a change")
+	require.Equal(t, RunStateStart, env.Runstate)
+	require.Equal(t, []string{"GET"}, methods, "No runstate change should be requested")
+
+	methods, served = nil, mixedJson
+	_, err = (&Environment{Id: "1"}).ChangeRunstate(client, RunStateStart, RunStateStart)
+	require.NoError(t, err)
+	require.Equal(t, "PUT", methods[1], "A change should be requested while any VM is in another runstate")
+}
+
+func TestVmRunstateError(t *testing.T) {
+	err := &VmRunstateError{EnvironmentId: "1", Runstate: RunStateStop, Vms: map[string]string{
+		"1002": RunStateStart,
+		"1001": "VMware Tools are not running",
+	}}
+	require.Equal(t, "Unable to change environment 1 to stopped, VM 1001: VMware Tools are not running; VM 1002: running", err.Error())
+}
+
+func TestUpdateEnvironment(t *testing.T) {
+	envJson := readJson(t, "testdata/environment-1.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var body string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "PUT", r.Method)
+		require.Equal(t, "/configurations/1.json", r.URL.Path)
+		require.Equal(t, AcceptHeaderV2, r.Header.Get("Accept"))
+		b, _ := ioutil.ReadAll(r.Body)
+		body = strings.TrimSpace(string(b))
+		fmt.Fprintln(w, envJson)
+	})
+
+	env, err := UpdateEnvironment(client, "1", &EnvironmentUpdate{
+		Description:     String("Nightly build"),
+		Owner:           String("15386"),
+		SuspendOnIdle:   Int(3600),
+		SuspendAtTime:   String(""),
+		DisableInternet: Bool(true),
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"description": "Nightly build", "owner": "15386", "suspend_on_idle": 3600,
+		"suspend_at_time": null, "disable_internet": true}`, body, "Only set fields should be sent")
+
+	require.Equal(t, "https://cloud.skytap.com/users/15386", env.Owner)
+	require.Equal(t, "ed40f5c2a440bbe2cbb4a97a45edbbe690d4f054", env.LockVersion)
+	require.False(t, env.Routable)
+	require.Nil(t, env.SuspendOnIdle)
+
+	_, err = env.Update(client, &EnvironmentUpdate{SuspendOnIdle: Int(0), Routable: Bool(false)})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"suspend_on_idle": null, "routable": false}`, body)
+}
+
+func TestRenameEnvironment(t *testing.T) {
+	envJson := readJson(t, "testdata/environment-1.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var requests []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+		require.Equal(t, AcceptHeaderV1, r.Header.Get("Accept"), "Renames should use the V1 API")
+		fmt.Fprintln(w, envJson)
+	})
+
+	_, err := RenameEnvironment(client, "1", "renamed", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{`PUT /configurations/1.json {"name":"renamed"}`}, requests)
+
+	requests = nil
+	env, err := RenameEnvironment(client, "1", "renamed", true)
+	require.NoError(t, err)
+	require.Equal(t, RunStateStop, env.Runstate, "restartEnv should not start the environment")
+	require.Equal(t, []string{`PUT /configurations/1.json {"name":"renamed"}`}, requests, "restartEnv should be ignored")
+}
@@ -0,0 +1,190 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ Error returned for any non successful response from the skytap API.
+*/
+type APIError struct {
+	// HTTP status code of the response.
+	StatusCode int
+	// HTTP method of the failed request.
+	Method string
+	// Full URL of the failed request.
+	Url string
+	// Error messages reported by skytap, if any.
+	Messages []string
+	// Parsed Retry-After header, zero if not present.
+	RetryAfter time.Duration
+	// Raw response body.
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("%s %s: received status %d %s from skytap API, but no additional error info", e.Method, e.Url, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Url, e.StatusCode, e.Message())
+}
+
+/*
+ All skytap error messages joined into a single string.
+*/
+func (e *APIError) Message() string {
+	return strings.Join(e.Messages, "; ")
+}
+
+/*
+ True if err is an APIError for a resource that doesn't exist (404).
+*/
+func IsNotFound(err error) bool { return hasStatus(err, http.StatusNotFound) }
+
+/*
+ True if err is an APIError for a resource that is locked or busy (423). A conflict (409) is reported by IsConflict.
+*/
+func IsBusy(err error) bool { return hasStatus(err, http.StatusLocked) }
+
+/*
+ True if err is an APIError because the resource changed since it was read, such as a lockversion mismatch (409).
+*/
+func IsConflict(err error) bool { return hasStatus(err, http.StatusConflict) }
+
+/*
+ True if err is an APIError due to the account being throttled (429).
+*/
+func IsRateLimited(err error) bool { return hasStatus(err, http.StatusTooManyRequests) }
+
+/*
+ True if err is an APIError due to the request failing validation (422).
+*/
+func IsValidation(err error) bool { return hasStatus(err, http.StatusUnprocessableEntity) }
+
+func hasStatus(err error, codes ...int) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ Builds an APIError from a failed request and its already read response body.
+*/
+func newAPIError(req *http.Request, resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		Messages:   decodeErrorMessages(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Body:       body,
+	}
+}
+
+/*
+ Skytap reports errors either as {"error": "..."} or {"errors": [...]}, the latter sometimes keyed by field.
+*/
+func decodeErrorMessages(body []byte) []string {
+	shape := struct {
+		Error  json.RawMessage `json:"error"`
+		Errors json.RawMessage `json:"errors"`
+	}{}
+	if err := json.Unmarshal(body, &shape); err != nil {
+		return nil
+	}
+	messages := flattenErrorMessages("", shape.Error)
+	return append(messages, flattenErrorMessages("", shape.Errors)...)
+}
+
+func flattenErrorMessages(prefix string, raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		if str == "" {
+			return nil
+		}
+		return []string{prefix + str}
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		var messages []string
+		for _, item := range list {
+			messages = append(messages, flattenErrorMessages(prefix, item)...)
+		}
+		return messages
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err == nil {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var messages []string
+		for _, key := range keys {
+			messages = append(messages, flattenErrorMessages(prefix+key+": ", fields[key])...)
+		}
+		return messages
+	}
+
+	// Numbers, booleans, null.
+	if s := string(raw); s != "null" && s != "false" {
+		return []string{prefix + s}
+	}
+	return nil
+}
+
+/*
+ Parses a Retry-After header, given either in seconds or as an HTTP date.
+*/
+func parseRetryAfter(after string) time.Duration {
+	if after == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseInt(strings.TrimSpace(after), 10, 32); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(after); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeErrorMessages(t *testing.T) {
+	require.Equal(t, []string{"Environment not found"}, decodeErrorMessages([]byte(`{"error": "Environment not found"}`)))
+	require.Equal(t, []string{"first", "second"}, decodeErrorMessages([]byte(`{"errors": ["first", "second"]}`)))
+	require.Equal(t, []string{"name: can't be blank", "subnet: is invalid"},
+		decodeErrorMessages([]byte(`{"errors": {"subnet": ["is invalid"], "name": "can't be blank"}}`)))
+	require.Nil(t, decodeErrorMessages([]byte(`{"error": ""}`)))
+	require.Nil(t, decodeErrorMessages([]byte(`<html>Bad gateway</html>`)))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	require.Equal(t, 30*time.Second, parseRetryAfter("30"))
+	require.Equal(t, time.Duration(0), parseRetryAfter(""))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	d := parseRetryAfter(at)
+	require.True(t, d > 50*time.Second && d <= time.Minute, "Should parse HTTP date, got %s", d)
+}
+
+func TestAPIErrorNotFound(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		fmt.Fprintln(w, `{"error": "Environment not found"}`)
+	})
+
+	_, err := GetEnvironment(client, "1")
+	require.Error(t, err)
+	require.True(t, IsNotFound(err), "Should be a not found error")
+	require.False(t, IsBusy(err))
+
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok, "Should be an *APIError")
+	require.Equal(t, 404, apiErr.StatusCode)
+	require.Equal(t, "GET", apiErr.Method)
+	require.Equal(t, server.URL+"/configurations/1.json", apiErr.Url)
+	require.Equal(t, []string{"Environment not found"}, apiErr.Messages)
+	require.Contains(t, string(apiErr.Body), "Environment not found")
+}
+
+func TestAPIErrorValidation(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `{"errors": ["Subnet is invalid"]}`)
+	})
+
+	_, err := CreateManualNetwork(client, "1", "API Network", "bad", "10.0.1.254")
+	require.True(t, IsValidation(err), "Should be a validation error")
+	require.Contains(t, err.Error(), "Subnet is invalid")
+}
+
+func TestAPIErrorNoDetails(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = NoRetryPolicy{}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	})
+
+	err := DeleteEnvironment(client, "1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no additional error info")
+	require.False(t, IsNotFound(err))
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"log/slog"
+)
+
+/*
+ Structured logger the SDK writes to. The message is followed by alternating keys and values.
+
+ *slog.Logger satisfies this interface, other logging libraries need a small adapter.
+*/
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+/*
+ Logger which discards everything, used when a client has no Logger.
+*/
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...interface{}) {}
+func (NopLogger) Info(string, ...interface{})  {}
+func (NopLogger) Warn(string, ...interface{})  {}
+func (NopLogger) Error(string, ...interface{}) {}
+
+/*
+ Adapt a log/slog logger, nil uses slog.Default().
+*/
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger
+}
+
+func (client SkytapClient) logger() Logger {
+	if client.Logger != nil {
+		return client.Logger
+	}
+	return NopLogger{}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	buf := &bytes.Buffer{}
+	client := skytapClient(t)
+	client.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	server := getMockServerForString(&client, vmJson)
+	defer server.Close()
+
+	err := DeleteVirtualMachine(client, "1001")
+	require.NoError(t, err)
+
+	out := buf.String()
+	require.Contains(t, out, `msg="Deleting VM" vmId=1001`)
+	require.Contains(t, out, `msg="Made request" method=DELETE`)
+	require.NotContains(t, out, "Ubuntu VM", "Response bodies should not be logged")
+}
+
+func TestNopLoggerByDefault(t *testing.T) {
+	client := NewClient()
+	require.Equal(t, NopLogger{}, client.logger())
+
+	logger := NewSlogLogger(nil)
+	client = NewClient(WithLogger(logger))
+	require.Equal(t, logger, client.logger())
+}
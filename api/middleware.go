@@ -0,0 +1,100 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+/*
+ A single attempt at sending a skytap API request, retries are separate attempts of the same request.
+*/
+type RequestAttempt struct {
+	// Fully prepared request, including authentication and headers.
+	Request *http.Request
+	// Attempt number, starting at 1.
+	Attempt int
+}
+
+/*
+ Sends a request attempt. The returned response body is buffered in memory.
+*/
+type RequestHandler func(attempt *RequestAttempt) (*http.Response, error)
+
+/*
+ Client wide interceptor around every request attempt, for instance to add headers, audit calls or inject faults.
+
+ A middleware may modify the request before calling next, inspect or replace the response afterwards, or return
+ without calling next at all. Middleware that reads a response body must replace it with an unread copy.
+*/
+type Middleware func(next RequestHandler) RequestHandler
+
+/*
+ Outcome of a request attempt, as reported by ObserveRequests.
+*/
+type RequestStats struct {
+	Method  string
+	Path    string
+	Attempt int
+	// Zero if no response was received.
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+/*
+ Middleware which calls observe after every request attempt, for metrics or auditing.
+*/
+func ObserveRequests(observe func(stats RequestStats)) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(attempt *RequestAttempt) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next(attempt)
+
+			stats := RequestStats{
+				Method:  attempt.Request.Method,
+				Path:    attempt.Request.URL.Path,
+				Attempt: attempt.Attempt,
+				Latency: time.Since(start),
+				Err:     err,
+			}
+			if resp != nil {
+				stats.StatusCode = resp.StatusCode
+			}
+			observe(stats)
+			return resp, err
+		}
+	}
+}
+
+/*
+ Middleware which sets a header on every request attempt, for example a correlation id.
+*/
+func SetHeader(key string, value string) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(attempt *RequestAttempt) (*http.Response, error) {
+			attempt.Request.Header.Set(key, value)
+			return next(attempt)
+		}
+	}
+}
+
+func chainMiddleware(middleware []Middleware, handler RequestHandler) RequestHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
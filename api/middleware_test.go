@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveRequests(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	var mu sync.Mutex
+	var stats []RequestStats
+
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(3)
+	client.Middleware = []Middleware{ObserveRequests(func(s RequestStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		stats = append(stats, s)
+	})}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = &scriptedHandler{statuses: []int{423, 200}, body: vmJson}
+
+	_, err := GetVirtualMachine(client, "1001")
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(stats))
+	require.Equal(t, "GET", stats[0].Method)
+	require.Equal(t, "/vms/1001", stats[0].Path)
+	require.Equal(t, 1, stats[0].Attempt)
+	require.Equal(t, 423, stats[0].StatusCode)
+	require.Equal(t, 2, stats[1].Attempt)
+	require.Equal(t, 200, stats[1].StatusCode)
+	require.True(t, stats[1].Latency > 0)
+}
+
+func TestMiddlewareOrderAndHeaders(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next RequestHandler) RequestHandler {
+			return func(attempt *RequestAttempt) (*http.Response, error) {
+				order = append(order, name+" before")
+				resp, err := next(attempt)
+				order = append(order, name+" after")
+				return resp, err
+			}
+		}
+	}
+
+	client := skytapClient(t)
+	client.Middleware = []Middleware{trace("outer"), SetHeader("X-Correlation-Id", "abc-123"), trace("inner")}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "abc-123", r.Header.Get("X-Correlation-Id"))
+	})
+
+	err := DeleteVirtualMachine(client, "1001")
+	require.NoError(t, err)
+	require.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, order)
+}
+
+func TestMiddlewareFaultInjection(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	// Fail every first attempt without reaching the server.
+	injectFault := func(next RequestHandler) RequestHandler {
+		return func(attempt *RequestAttempt) (*http.Response, error) {
+			if attempt.Attempt == 1 {
+				return &http.Response{
+					StatusCode: 503,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(`{"error": "injected"}`)),
+					Request:    attempt.Request,
+				}, nil
+			}
+			return next(attempt)
+		}
+	}
+
+	client := skytapClient(t)
+	client.Middleware = []Middleware{injectFault}
+	server := getMockServerForString(&client, vmJson)
+	defer server.Close()
+
+	client.RetryPolicy = NoRetryPolicy{}
+	_, err := GetVirtualMachine(client, "1001")
+	require.Contains(t, err.Error(), "injected")
+
+	client.RetryPolicy = fastRetryPolicy(1)
+	vm, err := GetVirtualMachine(client, "1001")
+	require.NoError(t, err)
+	require.Equal(t, "1001", vm.Id)
+}
+
+func TestMiddlewareWithoutResponse(t *testing.T) {
+	broken := func(next RequestHandler) RequestHandler {
+		return func(attempt *RequestAttempt) (*http.Response, error) {
+			return nil, nil
+		}
+	}
+
+	client := skytapClient(t)
+	client.Middleware = []Middleware{broken}
+	client.RetryPolicy = NoRetryPolicy{}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	_, err := GetVirtualMachine(client, "1001")
+	require.Error(t, err, "A missing response should be an error, not a panic")
+	require.Contains(t, err.Error(), "No response or error returned")
+}
+
+func TestMiddlewareResponseWithoutBody(t *testing.T) {
+	unavailable := func(next RequestHandler) RequestHandler {
+		return func(attempt *RequestAttempt) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+		}
+	}
+
+	client := skytapClient(t)
+	client.Middleware = []Middleware{unavailable}
+	client.RetryPolicy = NoRetryPolicy{}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	_, err := GetVirtualMachine(client, "1001")
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok, "A response without a body should be handled like an empty one, got %v", err)
+	require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	require.Empty(t, apiErr.Body)
+}
+
+func TestMiddlewareCanReadBody(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	var seen string
+	audit := func(next RequestHandler) RequestHandler {
+		return func(attempt *RequestAttempt) (*http.Response, error) {
+			resp, err := next(attempt)
+			if err == nil {
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				seen = string(body)
+			}
+			return resp, err
+		}
+	}
+
+	client := skytapClient(t)
+	client.Middleware = []Middleware{audit}
+	server := getMockServerForString(&client, vmJson)
+	defer server.Close()
+
+	vm, err := GetVirtualMachine(client, "1001")
+	require.NoError(t, err)
+	require.Equal(t, "1001", vm.Id)
+	require.Contains(t, seen, "Ubuntu VM")
+}
@@ -18,8 +18,6 @@ import (
 	"fmt"
 
 	"github.com/dghubble/sling"
-
-	log "github.com/Sirupsen/logrus"
 )
 
 const (
@@ -27,6 +25,7 @@ const (
 	InterfacePath        = "interfaces"
 	VpnPath              = "vpns"
 	PublishedServicePath = "services"
+	PublicIpPath         = "ips"
 )
 
 /*
@@ -124,7 +123,7 @@ type Vpn struct {
 	Enabled       bool   `json:"enabled"`
 	NatEnabled    bool   `json:"nat_enabled"`
 	RemoteSubnets string `json:"remote_subnets"`
-	RemotePeerIp  string `json:"remote_subnets"`
+	RemotePeerIp  string `json:"remote_peer_ip"`
 	CanReconnect  bool   `json:"can_reconnect"`
 }
 
@@ -167,7 +166,7 @@ func CreateAutomaticNetwork(
 	subnet string,
 	domain string) (*Network, error) {
 
-	log.WithFields(log.Fields{"envId": envId, "network_name": name}).Info("Adding network to environment")
+	client.logger().Info("Adding network to environment", "envId", envId, "network_name", name)
 
 	createAutoNetwork := func(s *sling.Sling) *sling.Sling {
 		network := struct {
@@ -197,7 +196,7 @@ func CreateManualNetwork(
 	name string,
 	subnet string,
 	gateway string) (*Network, error) {
-	log.WithFields(log.Fields{"envId": envId, "network_name": name}).Info("Adding network to environment")
+	client.logger().Info("Adding network to environment", "envId", envId, "network_name", name)
 
 	createAutoNetwork := func(s *sling.Sling) *sling.Sling {
 		network := struct {
@@ -222,7 +221,7 @@ func CreateManualNetwork(
 
 // DeleteNetwork - delete a network from an environment
 func DeleteNetwork(client SkytapClient, envId string, netId string) error {
-	log.WithFields(log.Fields{"envId": envId, "netId": netId}).Info("Deleting network in environment")
+	client.logger().Info("Deleting network in environment", "envId", envId, "netId", netId)
 
 	deleteNet := func(s *sling.Sling) *sling.Sling {
 		return s.Delete(fmt.Sprintf("%s/%s/%s/%s", EnvironmentPath, envId, NetworkPath, netId))
@@ -250,7 +249,7 @@ func vpnForNetworkInEnvironmentPath(netId string, envId string, vpnId string) st
  Attach a network to a VPN, in the context of the given environment.
 */
 func (n *Network) AttachToVpn(client SkytapClient, envId string, vpnId string) (*AttachVpnResult, error) {
-	log.WithFields(log.Fields{"netId": n.Id, "vpnId": vpnId, "envId": envId}).Info("Attach network to VPN")
+	client.logger().Info("Attach network to VPN", "netId", n.Id, "vpnId", vpnId, "envId", envId)
 
 	attachBody := &AttachVpnBody{vpnId}
 	attach := func(s *sling.Sling) *sling.Sling {
@@ -260,7 +259,7 @@ func (n *Network) AttachToVpn(client SkytapClient, envId string, vpnId string) (
 	result := &AttachVpnResult{}
 	_, err := RunSkytapRequest(client, false, result, attach)
 	if err != nil {
-		log.WithFields(log.Fields{"envId": envId, "vpnId": vpnId, "networkId": n.Id, "requestBody": attachBody, "error": err}).Errorf("Unable to attach VPN to environment.")
+		client.logger().Error("Unable to attach VPN to environment.", "envId", envId, "vpnId", vpnId, "networkId", n.Id, "requestBody", attachBody, "error", err)
 		return result, err
 	}
 	return result, nil
@@ -284,7 +283,7 @@ func (n *Network) DisconnectFromVpn(client SkytapClient, envId string, vpnId str
  General method for manipulating VPN connection state.
 */
 func (n *Network) ChangeConnectionToVpn(client SkytapClient, envId string, vpnId string, connected bool) error {
-	log.WithFields(log.Fields{"netId": n.Id, "vpnId": vpnId, "envId": envId, "connected": connected}).Info("Change network VPN connection")
+	client.logger().Info("Change network VPN connection", "netId", n.Id, "vpnId", vpnId, "envId", envId, "connected", connected)
 
 	connectBody := &ConnectVpnBody{connected}
 
@@ -294,7 +293,7 @@ func (n *Network) ChangeConnectionToVpn(client SkytapClient, envId string, vpnId
 
 	_, err := RunSkytapRequest(client, false, nil, connect)
 	if err != nil {
-		log.WithFields(log.Fields{"envId": envId, "vpnId": vpnId, "networkId": n.Id, "requestBody": connectBody, "error": err}).Errorf("Unable to attach VPN to environment.")
+		client.logger().Error("Unable to attach VPN to environment.", "envId", envId, "vpnId", vpnId, "networkId", n.Id, "requestBody", connectBody, "error", err)
 	}
 	return err
 }
@@ -303,7 +302,7 @@ func (n *Network) ChangeConnectionToVpn(client SkytapClient, envId string, vpnId
  Detach a network from a VPN in the context of the given environment.
 */
 func (n *Network) DetachFromVpn(client SkytapClient, envId string, vpnId string) error {
-	log.WithFields(log.Fields{"netId": n.Id, "vpnId": vpnId, "envId": envId}).Info("Detach network from VPN")
+	client.logger().Info("Detach network from VPN", "netId", n.Id, "vpnId", vpnId, "envId", envId)
 
 	detach := func(s *sling.Sling) *sling.Sling {
 		return s.Delete(vpnForNetworkInEnvironmentPath(n.Id, envId, vpnId))
@@ -311,7 +310,7 @@ func (n *Network) DetachFromVpn(client SkytapClient, envId string, vpnId string)
 
 	_, err := RunSkytapRequest(client, false, nil, detach)
 	if err != nil {
-		log.WithFields(log.Fields{"envId": envId, "vpnId": vpnId, "networkId": n.Id, "error": err}).Errorf("Unable to detach VPN from environment.")
+		client.logger().Error("Unable to detach VPN from environment.", "envId", envId, "vpnId", vpnId, "networkId", n.Id, "error", err)
 	}
 	return err
 }
@@ -332,9 +331,29 @@ func GetVpn(client SkytapClient, vpnId string) (*Vpn, error) {
 	return vpn, err
 }
 
+/*
+ Calls fn with each VPN in the account, paged as given by opts.
+*/
+func ListVpns(client SkytapClient, opts *ListOptions, fn func(vpn *Vpn) error) error {
+	listVpns := func(s *sling.Sling) *sling.Sling {
+		return s.Get(VpnPath + ".json")
+	}
+	return ListEach(client, true, opts, listVpns, fn)
+}
+
+/*
+ Calls fn with each public IP address in the account, paged as given by opts.
+*/
+func ListPublicIps(client SkytapClient, opts *ListOptions, fn func(ip *PublicIp) error) error {
+	listIps := func(s *sling.Sling) *sling.Sling {
+		return s.Get(PublicIpPath + ".json")
+	}
+	return ListEach(client, true, opts, listIps, fn)
+}
+
 func (nic *NetworkInterface) AddPublishedService(client SkytapClient, port int, envId, vmId string) (*NetworkInterface, error) {
 
-	log.WithFields(log.Fields{"envId": envId, "vmId": vmId, "interfaceId": nic.Id}).Infof("Adding service")
+	client.logger().Info("Adding service", "envId", envId, "vmId", vmId, "interfaceId", nic.Id)
 
 	service := PublishedService{InternalPort: port}
 
@@ -343,14 +362,14 @@ func (nic *NetworkInterface) AddPublishedService(client SkytapClient, port int,
 		return s.Post(path).BodyJSON(service)
 	}
 
-	_, err := RunSkytapRequest(client, true, service, addReq)
+	_, err := RunSkytapRequest(client, true, &service, addReq)
 	if err != nil {
 		return nic, err
 	}
 
 	nic.PublishedServices = append(nic.PublishedServices, service)
 
-	log.WithField("publishedService", service).Info("Service Added")
+	client.logger().Info("Service Added", "publishedService", service)
 
 	return nic, err
 }
@@ -14,7 +14,7 @@ func TestCreateAutomaticNetwork(t *testing.T) {
 	netJson := readJson(t, "testdata/network-1.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, netJson)
+	server := getMockServerForString(&client, netJson)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,7 +36,7 @@ func TestCreateManualNetwork(t *testing.T) {
 	netJson := readJson(t, "testdata/network-2.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, netJson)
+	server := getMockServerForString(&client, netJson)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -57,7 +57,7 @@ func TestCreateManualNetwork(t *testing.T) {
 func TestDeleteNetwork(t *testing.T) {
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, "")
+	server := getMockServerForString(&client, "")
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -75,7 +75,7 @@ func TestAttachVpn(t *testing.T) {
 	attachVpnJson := readJson(t, "testdata/attach-vpn-1.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, envJson)
+	server := getMockServerForString(&client, envJson)
 	defer server.Close()
 
 	env, err := GetEnvironment(client, "1")
@@ -138,10 +138,26 @@ func TestAddPublishedService(t *testing.T) {
 	netJson := readJson(t, "testdata/network-1.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, netJson)
+	server := getMockServerForString(&client, netJson)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.Equal(t, "/configurations/1/vms/1001/interfaces/nic-1/services.json", r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"internal_port": 8080}`, string(body))
+		fmt.Fprintln(w, `{"id": "8080", "internal_port": 8080, "external_ip": "services-uswest.skytap.com", "external_port": 26160}`)
 	})
 
+	nic := &NetworkInterface{Id: "nic-1"}
+	nic, err := nic.AddPublishedService(client, 8080, "1", "1001")
+	require.NoError(t, err, "Error adding published service")
+	require.Len(t, nic.PublishedServices, 1)
+
+	service := nic.PublishedServices[0]
+	require.Equal(t, "8080", service.Id)
+	require.Equal(t, 8080, service.InternalPort)
+	require.Equal(t, "services-uswest.skytap.com", service.ExternalIp, "Response should be decoded into the service")
+	require.Equal(t, 26160, service.ExternalPort)
 }
@@ -0,0 +1,105 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+// Statuses of an Operation.
+const (
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+/*
+ Returned by Operation.Result while the operation hasn't finished.
+*/
+var ErrOperationRunning = errors.New("operation still running")
+
+/*
+ A call running in the background, such as a runstate change that waits for the resource to be ready. Its methods
+ may be used from any goroutine.
+
+ An operation runs with the context of the client it was started with; cancel that context to abandon it.
+*/
+type Operation[T any] struct {
+	done   chan struct{}
+	result T
+	err    error
+}
+
+/*
+ Runs fn in the background, returning an Operation for tracking it.
+*/
+func StartOperation[T any](client SkytapClient, fn func(client SkytapClient) (T, error)) *Operation[T] {
+	op := &Operation[T]{done: make(chan struct{})}
+	go func() {
+		op.result, op.err = fn(client)
+		close(op.done)
+	}()
+	return op
+}
+
+/*
+ Closed once the operation has finished.
+*/
+func (op *Operation[T]) Done() <-chan struct{} {
+	return op.done
+}
+
+/*
+ OperationRunning until the operation finishes, then OperationSucceeded or OperationFailed.
+*/
+func (op *Operation[T]) Status() string {
+	select {
+	case <-op.done:
+	default:
+		return OperationRunning
+	}
+	if _, err := op.Result(); err != nil {
+		return OperationFailed
+	}
+	return OperationSucceeded
+}
+
+/*
+ The operation's result and error, without waiting; ErrOperationRunning is returned if it hasn't finished.
+*/
+func (op *Operation[T]) Result() (T, error) {
+	select {
+	case <-op.done:
+	default:
+		var zero T
+		return zero, ErrOperationRunning
+	}
+	return op.result, op.err
+}
+
+/*
+ Waits for the operation to finish and returns its result. If ctx is done first its error is returned, and the
+ operation carries on.
+*/
+func (op *Operation[T]) Wait(ctx context.Context) (T, error) {
+	select {
+	case <-op.done:
+		return op.Result()
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
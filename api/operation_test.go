@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperation(t *testing.T) {
+	client := skytapClient(t)
+	release := make(chan struct{})
+	op := StartOperation(client, func(client SkytapClient) (string, error) {
+		<-release
+		return "done", nil
+	})
+
+	require.Equal(t, OperationRunning, op.Status())
+	_, err := op.Result()
+	require.Equal(t, ErrOperationRunning, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = op.Wait(ctx)
+	require.Equal(t, context.DeadlineExceeded, err, "Wait should give up with the context")
+
+	close(release)
+	result, err := op.Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "done", result)
+	<-op.Done()
+	require.Equal(t, OperationSucceeded, op.Status())
+	result, err = op.Result()
+	require.NoError(t, err)
+	require.Equal(t, "done", result)
+}
+
+func TestOperationFailed(t *testing.T) {
+	client := skytapClient(t)
+	failure := fmt.Errorf("failed")
+	op := StartOperation(client, func(client SkytapClient) (*Environment, error) {
+		return nil, failure
+	})
+
+	_, err := op.Wait(context.Background())
+	require.Equal(t, failure, err)
+	require.Equal(t, OperationFailed, op.Status())
+}
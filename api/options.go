@@ -0,0 +1,233 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHttpTimeout = 2 * time.Minute
+)
+
+/*
+ Customizes a client during construction, see NewClient.
+*/
+type ClientOption func(*clientConfig)
+
+/*
+ Settings collected from options, applied once all options have run so they can be given in any order.
+*/
+type clientConfig struct {
+	client     *SkytapClient
+	httpClient *http.Client
+	timeout    time.Duration
+	transport  http.RoundTripper
+	proxy      func(*http.Request) (*url.URL, error)
+	tlsConfig  *tls.Config
+}
+
+/*
+ Create a new client configured by the given options.
+
+ Unless overridden, the client talks to the production skytap API with a DefaultHttpTimeout timeout and the default
+ retry policy.
+*/
+func NewClient(opts ...ClientOption) *SkytapClient {
+	config := &clientConfig{client: &SkytapClient{}, timeout: DefaultHttpTimeout}
+	for _, opt := range opts {
+		opt(config)
+	}
+	config.client.HttpClient = config.buildHttpClient()
+	return config.client
+}
+
+/*
+ Create a new client from a username and API key, with additional options.
+*/
+func NewSkytapClient(username string, apiKey string, opts ...ClientOption) *SkytapClient {
+	return NewClient(append([]ClientOption{WithCredentials(username, apiKey)}, opts...)...)
+}
+
+/*
+ Create a new client from credentials, with additional options.
+*/
+func NewSkytapClientFromCredentials(credentials SkytapCredentials, opts ...ClientOption) *SkytapClient {
+	return NewSkytapClient(credentials.Username, credentials.ApiKey, opts...)
+}
+
+func (config *clientConfig) buildHttpClient() *http.Client {
+	httpClient := &http.Client{Timeout: config.timeout}
+	if config.httpClient != nil {
+		// A copy, so that wrapping the transport doesn't affect other users of the caller's client.
+		shared := *config.httpClient
+		httpClient = &shared
+	}
+	transport := config.transport
+	if config.proxy != nil || config.tlsConfig != nil {
+		var base *http.Transport
+		if t, ok := transport.(*http.Transport); ok {
+			base = t.Clone()
+		} else if transport == nil {
+			base = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		// Proxy and TLS settings can't be applied to an arbitrary RoundTripper, which must handle them itself.
+		if base != nil {
+			if config.proxy != nil {
+				base.Proxy = config.proxy
+			}
+			if config.tlsConfig != nil {
+				base.TLSClientConfig = config.tlsConfig
+			}
+			transport = base
+		}
+	}
+	if transport != nil {
+		httpClient.Transport = transport
+	}
+	return httpClient
+}
+
+/*
+ Authenticate with the given skytap username and API key.
+*/
+func WithCredentials(username string, apiKey string) ClientOption {
+	return func(config *clientConfig) {
+		config.client.Credentials = SkytapCredentials{Username: username, ApiKey: apiKey}
+	}
+}
+
+/*
+ Overall timeout for each HTTP request attempt, zero means no timeout.
+*/
+func WithHttpTimeout(timeout time.Duration) ClientOption {
+	return func(config *clientConfig) {
+		config.timeout = timeout
+	}
+}
+
+/*
+ Use a copy of an existing http.Client, the timeout option is then ignored but transport, proxy and TLS options still
+ apply. The given client itself isn't modified.
+*/
+func WithHttpClient(httpClient *http.Client) ClientOption {
+	return func(config *clientConfig) {
+		config.httpClient = httpClient
+	}
+}
+
+/*
+ Send requests through a custom http.RoundTripper, for instance to instrument or record them.
+*/
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(config *clientConfig) {
+		config.transport = transport
+	}
+}
+
+/*
+ Send requests through the given HTTP proxy.
+*/
+func WithProxy(proxyUrl *url.URL) ClientOption {
+	return func(config *clientConfig) {
+		config.proxy = http.ProxyURL(proxyUrl)
+	}
+}
+
+/*
+ Use a custom TLS configuration, for instance to trust a private CA.
+*/
+func WithTLSConfig(tlsConfig *tls.Config) ClientOption {
+	return func(config *clientConfig) {
+		config.tlsConfig = tlsConfig
+	}
+}
+
+/*
+ Identify the calling tool in the User-Agent header, which is sent as UserAgent followed by the suffix.
+*/
+func WithUserAgent(suffix string) ClientOption {
+	return func(config *clientConfig) {
+		config.client.UserAgentSuffix = suffix
+	}
+}
+
+/*
+ Send the SDK's log output to the given logger.
+*/
+func WithLogger(logger Logger) ClientOption {
+	return func(config *clientConfig) {
+		config.client.Logger = logger
+	}
+}
+
+/*
+ Add interceptors around every request attempt, see Middleware.
+*/
+func WithMiddleware(middleware ...Middleware) ClientOption {
+	return func(config *clientConfig) {
+		config.client.Middleware = append(config.client.Middleware, middleware...)
+	}
+}
+
+/*
+ Use the given policy for retrying failed requests.
+*/
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(config *clientConfig) {
+		config.client.RetryPolicy = policy
+	}
+}
+
+/*
+ Poll resources as the given options say while waiting for them to change state.
+*/
+func WithWaitOptions(opts *WaitOptions) ClientOption {
+	return func(config *clientConfig) {
+		config.client.WaitOptions = opts
+	}
+}
+
+/*
+ Wait on the given limiter before each request, share the limiter between clients using the same account.
+*/
+func WithRateLimiter(limiter *RateLimiter) ClientOption {
+	return func(config *clientConfig) {
+		config.client.RateLimiter = limiter
+	}
+}
+
+/*
+ Point the client at the skytap API rooted at baseUrl, for example a staging endpoint or a local fake. The V2 API
+ is expected at baseUrl + "/v2".
+*/
+func WithBaseUrl(baseUrl string) ClientOption {
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	return WithBaseUrls(baseUrl, baseUrl+"/v2")
+}
+
+/*
+ Point the client at explicit V1 and V2 API roots.
+*/
+func WithBaseUrls(v1 string, v2 string) ClientOption {
+	return func(config *clientConfig) {
+		config.client.BaseUrlV1 = strings.TrimSuffix(v1, "/")
+		config.client.BaseUrlV2 = strings.TrimSuffix(v2, "/")
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingTransport struct {
+	next  http.RoundTripper
+	calls int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	return t.next.RoundTrip(req)
+}
+
+func TestNewSkytapClientDefaults(t *testing.T) {
+	client := NewClient(WithCredentials("user", "key"))
+	require.Equal(t, SkytapCredentials{"user", "key"}, client.Credentials)
+	require.Equal(t, DefaultHttpTimeout, client.HttpClient.Timeout)
+	require.Nil(t, client.HttpClient.Transport)
+	require.Equal(t, UserAgent, client.userAgent())
+}
+
+func TestNewSkytapClientOptions(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		fmt.Fprintln(w, `{"id": "1001"}`)
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{next: server.Client().Transport}
+	policy := NoRetryPolicy{}
+	limiter := NewRateLimiter(100, 10)
+	client := NewSkytapClientFromCredentials(SkytapCredentials{"user", "key"},
+		WithHttpTimeout(5*time.Second),
+		WithTransport(transport),
+		WithUserAgent("deployer/1.2"),
+		WithRetryPolicy(policy),
+		WithRateLimiter(limiter),
+		WithBaseUrl(server.URL))
+
+	require.Equal(t, 5*time.Second, client.HttpClient.Timeout)
+	require.Equal(t, policy, client.RetryPolicy)
+	require.Equal(t, limiter, client.RateLimiter)
+
+	vm, err := GetVirtualMachine(*client, "1001")
+	require.NoError(t, err)
+	require.Equal(t, "1001", vm.Id)
+	require.Equal(t, 1, transport.calls, "Should use the custom transport")
+	require.Equal(t, UserAgent+" deployer/1.2", userAgent)
+}
+
+func TestNewSkytapClientProxyAndTLS(t *testing.T) {
+	proxyUrl, _ := url.Parse("http://proxy.example:3128")
+	tlsConfig := &tls.Config{ServerName: "cloud.example"}
+
+	// Options may be given in any order.
+	client := NewClient(WithProxy(proxyUrl), WithTLSConfig(tlsConfig))
+
+	transport, ok := client.HttpClient.Transport.(*http.Transport)
+	require.True(t, ok, "Should build an http.Transport")
+	require.Equal(t, tlsConfig, transport.TLSClientConfig)
+	req, _ := http.NewRequest("GET", BaseUriV1, nil)
+	proxied, err := transport.Proxy(req)
+	require.NoError(t, err)
+	require.Equal(t, proxyUrl, proxied)
+	require.NotEqual(t, http.DefaultTransport, transport, "Should not modify the default transport")
+}
+
+func TestNewSkytapClientCredentials(t *testing.T) {
+	client := NewSkytapClient("user", "key")
+	require.Equal(t, SkytapCredentials{"user", "key"}, client.Credentials)
+
+	client = NewSkytapClient("user", "key", WithUserAgent("deployer/1.2"))
+	require.Equal(t, UserAgent+" deployer/1.2", client.userAgent())
+}
+
+func TestWithHttpClientCopies(t *testing.T) {
+	shared := &http.Client{Timeout: time.Second}
+	transport := &countingTransport{next: http.DefaultTransport}
+
+	client := NewClient(WithHttpClient(shared), WithTransport(transport))
+	require.Nil(t, shared.Transport, "The caller's client should not be modified")
+	require.Equal(t, transport, client.HttpClient.Transport)
+	require.Equal(t, time.Second, client.HttpClient.Timeout)
+	require.False(t, client.HttpClient == shared)
+}
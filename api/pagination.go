@@ -0,0 +1,202 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dghubble/sling"
+)
+
+const (
+	DefaultPageSize = 100
+
+	// Scopes for list filters.
+	ScopeMe      = "me"
+	ScopeCompany = "company"
+	ScopePublic  = "public"
+)
+
+/*
+ Return from a page or item callback to stop listing early, without an error being returned from the list call.
+*/
+var ErrStopIteration = errors.New("stop iteration")
+
+/*
+ Controls how a collection is paged through. The zero value lists everything, DefaultPageSize items at a time.
+*/
+type ListOptions struct {
+	// Number of items requested per page, if zero DefaultPageSize is used.
+	PageSize int
+	// Offset of the first item to list.
+	Offset int
+	// Maximum number of items to list, zero lists all of them.
+	Limit int
+}
+
+/*
+ Position of a page within its collection.
+*/
+type PageInfo struct {
+	// Offset of the first item on the page.
+	Offset int
+	// Total number of items in the collection, or -1 if the server didn't report it.
+	Total int
+}
+
+type pageQuery struct {
+	Count  int `url:"count"`
+	Offset int `url:"offset"`
+}
+
+var contentRangePattern = regexp.MustCompile(`(\d+)-(\d+)/(\d+|\*)`)
+
+/*
+ Calls fn with each page of a paginated collection, in order.
+
+ slingDecorator sets the collection path and any filter query; the count and offset parameters are added to it. Paging
+ stops after the last page, once opts.Limit items have been listed, or when fn returns an error. The last page is the
+ one reaching the Content-Range total, or an empty page; if the server gives no total, the first short page is taken
+ to be the last. If fn returns ErrStopIteration, nil is returned.
+*/
+func ListPages[T any](client SkytapClient, useV2 bool, opts *ListOptions, slingDecorator SlingDecorator, fn func(items []T, page PageInfo) error) error {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	offset, listed := opts.Offset, 0
+	for {
+		count := pageSize
+		if opts.Limit > 0 && opts.Limit-listed < count {
+			count = opts.Limit - listed
+		}
+		query := &pageQuery{Count: count, Offset: offset}
+		pageRequest := func(s *sling.Sling) *sling.Sling {
+			return slingDecorator(s).QueryStruct(query)
+		}
+
+		var items []T
+		resp, err := RunSkytapRequest(client, useV2, &items, pageRequest)
+		if err != nil {
+			return err
+		}
+		if len(items) > count {
+			items = items[:count]
+		}
+
+		page := PageInfo{Offset: offset, Total: contentRangeTotal(resp)}
+		if len(items) > 0 {
+			if err = fn(items, page); err != nil {
+				if err == ErrStopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+
+		offset += len(items)
+		listed += len(items)
+		if len(items) == 0 || (opts.Limit > 0 && listed >= opts.Limit) {
+			return nil
+		}
+		// Servers may return short pages, such as when capping the page size, so a short page is only taken to be the
+		// last when there is no total to go by.
+		if (page.Total >= 0 && offset >= page.Total) || (page.Total < 0 && len(items) < count) {
+			return nil
+		}
+	}
+}
+
+/*
+ Calls fn with each item of a paginated collection, in order. See ListPages.
+*/
+func ListEach[T any](client SkytapClient, useV2 bool, opts *ListOptions, slingDecorator SlingDecorator, fn func(item T) error) error {
+	return ListPages(client, useV2, opts, slingDecorator, func(items []T, page PageInfo) error {
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+ Collects every item of a paginated collection. See ListPages.
+*/
+func ListAll[T any](client SkytapClient, useV2 bool, opts *ListOptions, slingDecorator SlingDecorator) ([]T, error) {
+	var all []T
+	err := ListPages(client, useV2, opts, slingDecorator, func(items []T, page PageInfo) error {
+		all = append(all, items...)
+		return nil
+	})
+	return all, err
+}
+
+/*
+ A "key:value" criterion of a list filter query.
+*/
+type listQueryTerm struct {
+	key   string
+	value string
+	// Labels are "category:value", so a colon can't be rejected in them.
+	allowColon bool
+}
+
+/*
+ Joins the non-empty terms into a list filter query such as "name:web,region:US-West". The query syntax has no
+ escaping, so a value containing a comma, or a colon where one isn't expected, is an error rather than being sent as a
+ different filter.
+*/
+func listQuery(terms ...listQueryTerm) (string, error) {
+	var joined []string
+	for _, term := range terms {
+		if term.value == "" {
+			continue
+		}
+		if strings.Contains(term.value, ",") || (!term.allowColon && strings.Contains(term.value, ":")) {
+			return "", fmt.Errorf("Invalid %s filter %q, list filters can't contain commas or colons", term.key, term.value)
+		}
+		joined = append(joined, term.key+":"+term.value)
+	}
+	return strings.Join(joined, ","), nil
+}
+
+/*
+ Total from a "Content-Range: items 0-99/250" header, or -1 if it's missing or doesn't give one.
+*/
+func contentRangeTotal(resp *http.Response) int {
+	if resp == nil {
+		return -1
+	}
+	match := contentRangePattern.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	if match == nil || match[3] == "*" {
+		return -1
+	}
+	total, err := strconv.Atoi(match[3])
+	if err != nil {
+		return -1
+	}
+	return total
+}
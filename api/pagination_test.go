@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/dghubble/sling"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+ Serves a collection of numbered VPNs, honouring count and offset like the skytap API.
+*/
+type pagedHandler struct {
+	total        int
+	contentRange bool
+	// Most items returned on one page, if set.
+	maxPage int
+	offsets []int
+}
+
+func (h *pagedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	h.offsets = append(h.offsets, offset)
+	if h.maxPage > 0 && count > h.maxPage {
+		count = h.maxPage
+	}
+
+	vpns := []Vpn{}
+	for i := offset; i < offset+count && i < h.total; i++ {
+		vpns = append(vpns, Vpn{Id: fmt.Sprintf("vpn-%d", i)})
+	}
+	if h.contentRange && len(vpns) > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("items %d-%d/%d", offset, offset+len(vpns)-1, h.total))
+	}
+	json.NewEncoder(w).Encode(vpns)
+}
+
+func listVpnIds(t *testing.T, client SkytapClient, opts *ListOptions) []string {
+	var ids []string
+	err := ListVpns(client, opts, func(vpn *Vpn) error {
+		ids = append(ids, vpn.Id)
+		return nil
+	})
+	require.NoError(t, err)
+	return ids
+}
+
+func TestListPages(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &pagedHandler{total: 25, contentRange: true}
+	server.Config.Handler = handler
+
+	ids := listVpnIds(t, client, &ListOptions{PageSize: 10})
+	require.Equal(t, 25, len(ids))
+	require.Equal(t, "vpn-24", ids[24])
+	require.Equal(t, []int{0, 10, 20}, handler.offsets)
+
+	handler = &pagedHandler{total: 20, contentRange: true}
+	server.Config.Handler = handler
+	require.Equal(t, 20, len(listVpnIds(t, client, &ListOptions{PageSize: 10})))
+	require.Equal(t, []int{0, 10}, handler.offsets, "Should stop at the Content-Range total without an empty page")
+}
+
+func TestListPagesWithoutContentRange(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &pagedHandler{total: 20}
+	server.Config.Handler = handler
+
+	require.Equal(t, 20, len(listVpnIds(t, client, &ListOptions{PageSize: 10})))
+	require.Equal(t, []int{0, 10, 20}, handler.offsets, "Should stop at the first short page")
+
+	handler = &pagedHandler{total: 3}
+	server.Config.Handler = handler
+	require.Equal(t, 3, len(listVpnIds(t, client, nil)))
+	require.Equal(t, []int{0}, handler.offsets)
+}
+
+func TestListPagesCappedByServer(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &pagedHandler{total: 25, contentRange: true, maxPage: 10}
+	server.Config.Handler = handler
+
+	ids := listVpnIds(t, client, &ListOptions{PageSize: 100})
+	require.Equal(t, 25, len(ids), "Short pages should not end listing while the total says more remain")
+	require.Equal(t, "vpn-24", ids[24])
+	require.Equal(t, []int{0, 10, 20}, handler.offsets)
+
+	handler = &pagedHandler{total: 25, maxPage: 10}
+	server.Config.Handler = handler
+	require.Equal(t, 10, len(listVpnIds(t, client, &ListOptions{PageSize: 100})), "Without a total a short page is the last")
+}
+
+func TestListOffsetAndLimit(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &pagedHandler{total: 100, contentRange: true}
+	server.Config.Handler = handler
+
+	ids := listVpnIds(t, client, &ListOptions{PageSize: 10, Offset: 5, Limit: 15})
+	require.Equal(t, 15, len(ids))
+	require.Equal(t, "vpn-5", ids[0])
+	require.Equal(t, "vpn-19", ids[14])
+	require.Equal(t, []int{5, 15}, handler.offsets)
+}
+
+func TestListStopIteration(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &pagedHandler{total: 100, contentRange: true}
+	server.Config.Handler = handler
+
+	var pages []PageInfo
+	listVpns := func(s *sling.Sling) *sling.Sling { return s.Get(VpnPath + ".json") }
+	err := ListPages(client, true, &ListOptions{PageSize: 10}, listVpns, func(vpns []Vpn, page PageInfo) error {
+		pages = append(pages, page)
+		if len(pages) == 2 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	require.NoError(t, err, "ErrStopIteration should not be reported as an error")
+	require.Equal(t, []PageInfo{{Offset: 0, Total: 100}, {Offset: 10, Total: 100}}, pages)
+	require.Equal(t, 2, len(handler.offsets))
+
+	failure := fmt.Errorf("callback failed")
+	err = ListVpns(client, nil, func(vpn *Vpn) error { return failure })
+	require.Equal(t, failure, err)
+}
+
+func TestListAll(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = &pagedHandler{total: 7}
+	listVpns := func(s *sling.Sling) *sling.Sling { return s.Get(VpnPath + ".json") }
+	vpns, err := ListAll[Vpn](client, true, &ListOptions{PageSize: 3}, listVpns)
+	require.NoError(t, err)
+	require.Equal(t, 7, len(vpns))
+}
+
+func TestListError(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = NoRetryPolicy{}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = &scriptedHandler{statuses: []int{404}}
+	err := ListPublicIps(client, nil, func(ip *PublicIp) error { return nil })
+	require.True(t, IsNotFound(err))
+}
+
+func TestContentRangeTotal(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	require.Equal(t, -1, contentRangeTotal(resp))
+	resp.Header.Set("Content-Range", "items 0-99/250")
+	require.Equal(t, 250, contentRangeTotal(resp))
+	resp.Header.Set("Content-Range", "items 0-99/*")
+	require.Equal(t, -1, contentRangeTotal(resp))
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ Client side token bucket rate limiter, safe for use by many goroutines.
+
+ Share a single limiter between all clients using the same skytap account, every request attempt takes a token
+ before it is sent. Mutating requests (POST, PUT, PATCH, DELETE) can be given their own budget. When skytap
+ responds with a Retry-After header to a throttled request, all requests are held back until that time.
+*/
+type RateLimiter struct {
+	read  *tokenBucket
+	write *tokenBucket
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+/*
+ Create a limiter allowing requestsPerSecond on average, with bursts of up to burst requests.
+*/
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	bucket := newTokenBucket(requestsPerSecond, burst)
+	return &RateLimiter{read: bucket, write: bucket}
+}
+
+/*
+ Create a limiter with separate budgets for read (GET, HEAD) and mutating requests.
+*/
+func NewSplitRateLimiter(readPerSecond float64, readBurst int, writePerSecond float64, writeBurst int) *RateLimiter {
+	return &RateLimiter{read: newTokenBucket(readPerSecond, readBurst), write: newTokenBucket(writePerSecond, writeBurst)}
+}
+
+/*
+ Blocks until a request with the given HTTP method may be sent, or the context is done.
+*/
+func (l *RateLimiter) Wait(ctx context.Context, method string) error {
+	l.mu.Lock()
+	paused := time.Until(l.pausedUntil)
+	l.mu.Unlock()
+	if paused > 0 {
+		if err := sleepWithContext(ctx, paused); err != nil {
+			return err
+		}
+	}
+
+	bucket := l.read
+	if method != http.MethodGet && method != http.MethodHead {
+		bucket = l.write
+	}
+	wait := bucket.reserve(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleepWithContext(ctx, wait); err != nil {
+		bucket.cancel()
+		return err
+	}
+	return nil
+}
+
+/*
+ Holds back all requests for the given duration, used when skytap asks us to back off.
+*/
+func (l *RateLimiter) PauseFor(d time.Duration) {
+	until := time.Now().Add(d)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+/*
+ Takes a token, returning how long the caller must wait before it becomes available. Tokens may go negative, so
+ concurrent callers queue up behind each other.
+*/
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+/*
+ Returns a reserved token which wasn't used.
+*/
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucketBurstThenRate(t *testing.T) {
+	bucket := newTokenBucket(10, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, time.Duration(0), bucket.reserve(now), "Burst should not wait")
+	}
+	require.Equal(t, 100*time.Millisecond, bucket.reserve(now))
+	require.Equal(t, 200*time.Millisecond, bucket.reserve(now), "Queued callers should wait behind each other")
+
+	// After a second the bucket has refilled, but never beyond burst.
+	later := now.Add(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, time.Duration(0), bucket.reserve(later))
+	}
+	require.True(t, bucket.reserve(later) > 0)
+}
+
+func TestRateLimiterSplitBudgets(t *testing.T) {
+	limiter := NewSplitRateLimiter(1000, 10, 1, 1)
+	ctx := context.Background()
+
+	require.NoError(t, limiter.Wait(ctx, http.MethodPut))
+
+	// Reads are unaffected by the exhausted write budget.
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, limiter.Wait(ctx, http.MethodGet))
+	}
+	require.True(t, time.Since(start) < 500*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx, http.MethodDelete), "Write budget should be exhausted")
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	limiter := NewRateLimiter(1000, 10)
+	limiter.PauseFor(150 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, limiter.Wait(context.Background(), http.MethodGet))
+	require.True(t, time.Since(start) >= 100*time.Millisecond, "Should wait for the pause to end")
+}
+
+func TestRateLimiterSharedByRequests(t *testing.T) {
+	client := skytapClient(t)
+	client.RateLimiter = NewRateLimiter(20, 1)
+	server := getMockServerForString(&client, "{}")
+	defer server.Close()
+
+	start := time.Now()
+	errs := make(chan error, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := GetVirtualMachine(client, "1001")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.True(t, time.Since(start) >= 150*time.Millisecond, "5 requests at 20/s with burst 1 should take at least 200ms")
+}
+
+func TestRateLimiterAdaptsToRetryAfter(t *testing.T) {
+	client := skytapClient(t)
+	client.RateLimiter = NewRateLimiter(1000, 10)
+	client.RetryPolicy = NoRetryPolicy{}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = &scriptedHandler{
+		statuses: []int{429, 200},
+		headers:  map[int]http.Header{1: {"Retry-After": []string{"1"}}},
+		body:     "{}",
+	}
+
+	_, err := GetVirtualMachine(client, "1001")
+	require.True(t, IsRateLimited(err))
+
+	start := time.Now()
+	_, err = GetVirtualMachine(client, "1001")
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= 500*time.Millisecond, "Should hold back requests until Retry-After")
+}
@@ -15,15 +15,15 @@
 package api
 
 import (
-	"errors"
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"strconv"
 	"time"
 
 	"encoding/json"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/dghubble/sling"
 )
 
@@ -34,22 +34,8 @@ const (
 	BaseUriV2      = "https://cloud.skytap.com/v2"
 	MetadataUri    = "http://gw/skytap"
 	UserAgent      = "skytap-sdk-go"
-
-	maxRetries = 6
 )
 
-/*
- Added for testability.
-*/
-var baseUrlOveride = ""
-
-/*
- General skytap json error response.
-*/
-type SkytapApiError struct {
-	Error string `json:error`
-}
-
 /*
  Skytap metadata service response.
 */
@@ -71,20 +57,64 @@ type SkytapCredentials struct {
 type SkytapClient struct {
 	HttpClient  *http.Client
 	Credentials SkytapCredentials
+	// Policy for retrying failed requests, if nil the policy from NewDefaultRetryPolicy is used.
+	RetryPolicy RetryPolicy
+	// Optional limiter every request attempt waits on, may be shared between clients.
+	RateLimiter *RateLimiter
+	// How to poll resources while waiting on them, if nil the options from NewDefaultWaitOptions are used.
+	WaitOptions *WaitOptions
+	// Root of the V1 API, defaults to BaseUriV1.
+	BaseUrlV1 string
+	// Root of the V2 API, defaults to BaseUriV2.
+	BaseUrlV2 string
+	// Interceptors wrapping every request attempt, the first is outermost.
+	Middleware []Middleware
+	// Receives the SDK's log output, if nil nothing is logged.
+	Logger Logger
+	// Appended to the User-Agent header, so tools built on the SDK can identify themselves.
+	UserAgentSuffix string
+
+	ctx context.Context
+}
+
+/*
+ Returns the client's context, or context.Background if none has been set.
+
+ Every request made with the client, including retry and polling waits, is bound to this context.
+*/
+func (client SkytapClient) Context() context.Context {
+	if client.ctx != nil {
+		return client.ctx
+	}
+	return context.Background()
 }
 
 /*
- Create a new client from username and key.
+ Returns a copy of the client bound to the given context. Cancelling the context aborts in-flight requests,
+ retry waits and runstate polling made through the returned client.
 */
-func NewSkytapClient(username string, apiKey string) *SkytapClient {
-	return NewSkytapClientFromCredentials(SkytapCredentials{username, apiKey})
+func (client SkytapClient) WithContext(ctx context.Context) SkytapClient {
+	if ctx == nil {
+		panic("nil context")
+	}
+	client.ctx = ctx
+	return client
 }
 
 /*
- Create a new client from credentials
+ Root URL for V1 or V2 API requests made by this client.
 */
-func NewSkytapClientFromCredentials(credentials SkytapCredentials) *SkytapClient {
-	return &SkytapClient{&http.Client{}, credentials}
+func (client SkytapClient) baseUrl(useV2 bool) string {
+	if useV2 {
+		if client.BaseUrlV2 != "" {
+			return client.BaseUrlV2
+		}
+		return BaseUriV2
+	}
+	if client.BaseUrlV1 != "" {
+		return client.BaseUrlV1
+	}
+	return BaseUriV1
 }
 
 /*
@@ -116,36 +146,41 @@ type RunstateAwareResource interface {
  with the result of the last attempt.
 
  If requireStateChange is set, a transition must occur. The function will wait until the state changes or timeout.
+
+ The resource is polled as the client's WaitOptions say, see NewDefaultWaitOptions for the defaults. If the timeout
+ passes first a *WaitTimeoutError is returned.
 */
 func WaitUntilInState(client SkytapClient, desiredStates []string, r RunstateAwareResource, requireStateChange bool) (RunstateAwareResource, error) {
-	log.WithFields(log.Fields{"desiredStates": desiredStates, "resource": r}).Info("Waiting until resource is in desired state")
+	client.logger().Info("Waiting until resource is in desired state", "desiredStates", desiredStates, "resource", r)
 	start := time.Now()
 
-	current, err := r.Refresh(client)
-	if err != nil {
-		return current, err
-	}
-
-	hasChanged := !requireStateChange || current.RunstateStr() != r.RunstateStr()
-
-	maxBusyWaitPeriods := 20
-	waitPeriod := 10 * time.Second
-	for i := 0; i < maxBusyWaitPeriods && !(hasChanged && stringInSlice(current.RunstateStr(), desiredStates)); i++ {
-		time.Sleep(waitPeriod)
-		current, err = r.Refresh(client)
+	current := r
+	hasChanged := !requireStateChange
+	err := client.poll(func(client SkytapClient) (bool, error) {
+		refreshed, err := r.Refresh(client)
 		if err != nil {
-			return current, err
+			return false, err
 		}
+		current = refreshed
 		hasChanged = hasChanged || current.RunstateStr() != r.RunstateStr()
-	}
-	if !stringInSlice(current.RunstateStr(), desiredStates) {
-		return current, errors.New(fmt.Sprintf("Didn't achieve any desired runstate in %s after %d seconds, resource is in runstate %s", desiredStates, time.Now().Unix()-start.Unix(), current.RunstateStr()))
+		return hasChanged && stringInSlice(current.RunstateStr(), desiredStates), nil
+	})
+	if err == errWaitTimeout {
+		waited := time.Since(start)
+		return current, &WaitTimeoutError{Waited: waited, message: fmt.Sprintf("Didn't achieve any desired runstate in %s after %d seconds, resource is in runstate %s", desiredStates, waited/time.Second, current.RunstateStr())}
 	}
 	return current, err
 }
 
 /*
- Runs an initial skytap API request attempt, with retries.
+ WaitUntilInState, polling as opts say instead of the client's WaitOptions. If opts is nil the client's are used.
+*/
+func WaitUntilInStateWithOptions(client SkytapClient, opts *WaitOptions, desiredStates []string, r RunstateAwareResource, requireStateChange bool) (RunstateAwareResource, error) {
+	return WaitUntilInState(client.withCallWaitOptions(opts), desiredStates, r, requireStateChange)
+}
+
+/*
+ Runs an initial skytap API request attempt, with retries as decided by the client's RetryPolicy.
 
  Returns the resulting response, or error. If no error occurs, the response json will be present in respJson.
 
@@ -154,7 +189,28 @@ func WaitUntilInState(client SkytapClient, desiredStates []string, r RunstateAwa
  slingDecorator - Decorate request with specifics, set request path relative to root, add body, etc.
 */
 func RunSkytapRequest(client SkytapClient, useV2 bool, respJson interface{}, slingDecorator SlingDecorator) (*http.Response, error) {
-	return runSkytapRequestWithRetry(client, useV2, respJson, slingDecorator, 0)
+	policy := client.retryPolicy()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		req, resp, err := runSkytapRequestAttempt(client, useV2, respJson, slingDecorator, attempt)
+		if err == nil || req == nil || client.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait, retry := policy.NextRetry(attempt, time.Since(start), req, resp, err)
+		if !retry {
+			if attempt > 1 {
+				client.logger().Error("Giving up retrying request", "method", req.Method, "url", req.URL, "attempts", attempt, "error", err)
+			}
+			return resp, err
+		}
+
+		client.logger().Info("Request failed, retrying", "method", req.Method, "url", req.URL, "attempt", attempt, "retryIn", wait, "error", err)
+		if sleepErr := sleepWithContext(client.Context(), wait); sleepErr != nil {
+			return resp, sleepErr
+		}
+	}
 }
 
 /*
@@ -167,90 +223,128 @@ func GetSkytapResource(client SkytapClient, url string, respObj interface{}) (*h
 	return RunSkytapRequest(client, false, respObj, fromUrl)
 }
 
-/*
-  Runs a skytap API request attempt, retry number as specified by retryNum.
+func (client SkytapClient) userAgent() string {
+	if client.UserAgentSuffix == "" {
+		return UserAgent
+	}
+	return UserAgent + " " + client.UserAgentSuffix
+}
 
-*/
-func runSkytapRequestWithRetry(client SkytapClient, useV2 bool, respObj interface{}, slingDecorator SlingDecorator, retryNum int) (*http.Response, error) {
-	baseUrl := BaseUriV1
-	if baseUrlOveride != "" {
-		baseUrl = baseUrlOveride
-	} else if useV2 {
-		baseUrl = BaseUriV2
+func (client SkytapClient) retryPolicy() RetryPolicy {
+	if client.RetryPolicy != nil {
+		return client.RetryPolicy
 	}
+	return NewDefaultRetryPolicy()
+}
+
+/*
+ Runs a single skytap API request attempt. The request is rebuilt from the decorator, so each attempt sends a fresh body.
 
-	base := sling.New().Base(baseUrl + "/").Client(client.HttpClient)
+ Returns the request that was sent, which is nil if it couldn't be built.
+*/
+func runSkytapRequestAttempt(client SkytapClient, useV2 bool, respObj interface{}, slingDecorator SlingDecorator, attempt int) (*http.Request, *http.Response, error) {
+	base := sling.New().Base(client.baseUrl(useV2) + "/").Client(client.HttpClient)
 	s := slingDecorator(base)
-	skytapError := &SkytapApiError{}
 	req, err := s.Request()
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(client.Context())
 	req.SetBasicAuth(client.Credentials.Username, client.Credentials.ApiKey)
 	acceptHeader := AcceptHeaderV1
 	if useV2 {
 		acceptHeader = AcceptHeaderV2
 	}
 	req.Header.Set("Accept", acceptHeader)
-	req.Header.Set("User-Agent", UserAgent)
-	var resp *http.Response
-	resp, err = s.Do(req, respObj, skytapError)
-
-	returnError := err
-	logRequestResponse(req, resp, respObj, returnError)
-
-	if !isOkStatus(resp.StatusCode) {
-		if isBusy(resp.StatusCode) {
-			retrySecs := 10
-			after := resp.Header.Get("Retry-After")
-			if after != "" {
-				parsedSecs, parseErr := strconv.ParseInt(after, 10, 32)
-				if parseErr != nil {
-					log.Warnf("Couldn't parse Retry-After (%s)", after)
-				} else {
-					retrySecs = int(parsedSecs)
-				}
-			} else if retryNum <= maxRetries {
-				log.WithFields(log.Fields{
-					"method":         req.Method,
-					"url":            req.URL,
-					"retryNum":       retryNum,
-					"retryAfterSecs": retrySecs,
-				}).Info("Got resource busy response, retrying")
-				time.Sleep(time.Duration(retrySecs) * time.Second)
-				runSkytapRequestWithRetry(client, useV2, respObj, slingDecorator, retryNum+1)
-			} else {
-				log.WithFields(log.Fields{"url": req.URL, "maxRetries": maxRetries, "error": err}).Error("Maximum retries reached")
-				returnError = errors.New(fmt.Sprintf("Maximum retries (%d) reached calling %s(%s), resource is still busy", maxRetries, req.Method, req.URL))
-			}
-		} else {
-			if skytapError.Error != "" {
-				returnError = errors.New(skytapError.Error)
-			} else if err == nil {
-				returnError = fmt.Errorf("Received error status code calling SkyTap API, but no additional error info: %s", resp.Status)
-				logRequestResponse(req, resp, respObj, returnError)
-			}
+	req.Header.Set("User-Agent", client.userAgent())
+
+	if client.RateLimiter != nil {
+		if err = client.RateLimiter.Wait(client.Context(), req.Method); err != nil {
+			return req, nil, err
 		}
 	}
-	return resp, returnError
-}
 
-func logRequestResponse(req *http.Request, resp *http.Response, respObj interface{}, err error) {
+	handler := chainMiddleware(client.Middleware, func(a *RequestAttempt) (*http.Response, error) {
+		return doSkytapRequest(client.HttpClient, a.Request)
+	})
+	resp, err := handler(&RequestAttempt{Request: req, Attempt: attempt})
+	if err == nil && resp == nil {
+		err = fmt.Errorf("No response or error returned for %s %s, a middleware may be broken", req.Method, req.URL)
+	}
+	var body []byte
+	if err == nil {
+		// Responses made up by middleware may have no body, which is read as an empty one.
+		if resp.Body != nil {
+			body, err = ioutil.ReadAll(resp.Body)
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err != nil {
+		client.logger().Warn("Request failed", "method", req.Method, "url", req.URL, "error", err)
+		return req, resp, err
+	}
 
-	jsonStr, err := json.Marshal(respObj)
+	if isOkStatus(resp.StatusCode) {
+		err = decodeResponseBody(body, respObj)
+		client.logRequestResponse(req, resp, err)
+		return req, resp, err
+	}
+
+	apiErr := newAPIError(req, resp, body)
+	client.logRequestResponse(req, resp, apiErr)
+	if client.RateLimiter != nil && IsRateLimited(apiErr) && apiErr.RetryAfter > 0 {
+		client.RateLimiter.PauseFor(apiErr.RetryAfter)
+	}
+	return req, resp, apiErr
+}
+
+/*
+ Executes the request and reads the whole response body, which is left readable in memory on the returned response.
+*/
+func doSkytapRequest(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Errorf("Couldn't marshall response: %s", err)
+		return resp, err
 	}
+	defer resp.Body.Close()
 
-	entry := log.WithFields(log.Fields{
-		"method":         req.Method,
-		"url":            req.URL,
-		"status":         resp.Status,
-		"marshallError":  err,
-		"responseObject": string(jsonStr),
-	})
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, err
+}
 
+/*
+ Decodes a successful response body into respObj, empty bodies and a nil respObj are ignored.
+*/
+func decodeResponseBody(body []byte, respObj interface{}) error {
+	if respObj == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, respObj)
+}
+
+func (client SkytapClient) logRequestResponse(req *http.Request, resp *http.Response, err error) {
 	if err != nil {
-		entry.Error("Request caused error")
+		client.logger().Warn("Request caused error", "method", req.Method, "url", req.URL, "status", resp.Status, "error", err)
 	} else {
-		entry.Debug("Made request")
+		client.logger().Debug("Made request", "method", req.Method, "url", req.URL, "status", resp.Status)
+	}
+}
+
+/*
+ Sleeps for the given duration, returning early with the context's error if it is done first.
+*/
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
 
@@ -264,15 +358,13 @@ func stringInSlice(a string, list []string) bool {
 }
 
 func IsRunningInSkytap() bool {
-	skytapError := &SkytapApiError{}
 	response := &SkytapMetadata{}
 
 	client := sling.New().Client(nil)
 	req, err := sling.New().Get(MetadataUri).Request()
-	resp, err := client.Do(req, response, skytapError)
 	if err != nil {
-		log.Errorf("Failure calling Metadata Service (resp, err), %s, %s", resp, err)
 		return false
 	}
-	return true
+	_, err = client.Do(req, response, nil)
+	return err == nil
 }
@@ -1 +1,109 @@
 package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitUntilInStateCancelled(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	client := skytapClient(t)
+	server := getMockServerForString(&client, strings.Replace(vmJson, "stopped", "busy", 1))
+	defer server.Close()
+
+	vm, err := GetVirtualMachine(client, "1001")
+	require.NoError(t, err, "Error getting vm")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = vm.WaitUntilReady(client.WithContext(ctx))
+	require.Equal(t, context.Canceled, err)
+	require.True(t, time.Since(start) < 5*time.Second, "Should return promptly once cancelled")
+}
+
+func TestBusyRetryCancelled(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(423)
+		fmt.Fprintln(w, `{"error":"busy"}`)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := GetVirtualMachine(client.WithContext(ctx), "1001")
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, time.Since(start) < 5*time.Second, "Should return promptly once cancelled")
+}
+
+func TestRequestUsesClientContext(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := GetVirtualMachine(client.WithContext(ctx), "1001")
+	require.Error(t, err, "Request with a cancelled context should fail")
+	require.True(t, strings.Contains(err.Error(), context.Canceled.Error()))
+}
+
+func TestClientsWithSeparateEndpoints(t *testing.T) {
+	serverFor := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/v2/configurations/1.json", r.URL.Path)
+			fmt.Fprintf(w, `{"id": "1", "name": "%s"}`, name)
+		}))
+	}
+	staging := serverFor("staging")
+	defer staging.Close()
+	local := serverFor("local")
+	defer local.Close()
+
+	stagingClient := NewClient(WithCredentials("user", "key"), WithBaseUrl(staging.URL))
+	localClient := NewClient(WithCredentials("user", "key"), WithBaseUrl(local.URL+"/"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			env, err := GetEnvironment(*stagingClient, "1")
+			require.NoError(t, err)
+			require.Equal(t, "staging", env.Name)
+		}()
+		go func() {
+			defer wg.Done()
+			env, err := GetEnvironment(*localClient, "1")
+			require.NoError(t, err)
+			require.Equal(t, "local", env.Name)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDefaultBaseUrls(t *testing.T) {
+	client := NewClient()
+	require.Equal(t, BaseUriV1, client.baseUrl(false))
+	require.Equal(t, BaseUriV2, client.baseUrl(true))
+
+	client = NewClient(WithCredentials("user", "key"), WithBaseUrls("http://localhost:8080/", "http://localhost:8081/api"))
+	require.Equal(t, "http://localhost:8080", client.baseUrl(false))
+	require.Equal(t, "http://localhost:8081/api", client.baseUrl(true))
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+/*
+ Decides whether a failed request attempt should be retried, and how long to wait before doing so.
+*/
+type RetryPolicy interface {
+	// Called after each failed attempt. attempt starts at 1, elapsed is the time since the first attempt was sent.
+	// resp is nil if the request failed without a response, in which case err holds the transport error.
+	// Returns the delay before the next attempt, and false if no further attempt should be made.
+	NextRetry(attempt int, elapsed time.Duration, req *http.Request, resp *http.Response, err error) (time.Duration, bool)
+}
+
+/*
+ Retry policy with exponential backoff and jitter.
+
+ Locked (423), throttled (429) and server error (5xx) responses are retried, as are transport errors. POST requests
+ are not idempotent, so they are only retried when skytap rejected them without processing (423, 429). When the
+ response carries a Retry-After header for 423, 429 or 503, the server's delay is used instead of the backoff.
+*/
+type BackoffRetryPolicy struct {
+	// Maximum number of retries after the initial attempt.
+	MaxRetries int
+	// Delay before the first retry.
+	InitialInterval time.Duration
+	// Upper bound for the computed backoff delay.
+	MaxInterval time.Duration
+	// Factor the delay grows by after each retry.
+	Multiplier float64
+	// Randomization factor in [0, 1], the delay is picked from delay * (1 +/- Jitter).
+	Jitter float64
+	// No retry is attempted if it would start later than this after the first attempt. Zero means no limit.
+	MaxElapsedTime time.Duration
+}
+
+/*
+ Create the retry policy used by clients that don't configure one.
+*/
+func NewDefaultRetryPolicy() *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{
+		MaxRetries:      6,
+		InitialInterval: 2 * time.Second,
+		MaxInterval:     60 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxElapsedTime:  5 * time.Minute,
+	}
+}
+
+/*
+ Retry policy which never retries.
+*/
+type NoRetryPolicy struct{}
+
+func (NoRetryPolicy) NextRetry(int, time.Duration, *http.Request, *http.Response, error) (time.Duration, bool) {
+	return 0, false
+}
+
+func (p *BackoffRetryPolicy) NextRetry(attempt int, elapsed time.Duration, req *http.Request, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt > p.MaxRetries || !isRetryable(req, resp) {
+		return 0, false
+	}
+
+	delay := p.backoff(attempt)
+	if resp != nil && honorsRetryAfter(resp.StatusCode) {
+		if after := parseRetryAfter(resp.Header.Get("Retry-After")); after > 0 {
+			delay = after
+		}
+	}
+
+	if p.MaxElapsedTime > 0 && elapsed+delay > p.MaxElapsedTime {
+		return 0, false
+	}
+	return delay, true
+}
+
+func (p *BackoffRetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		delay = delay * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(delay)
+}
+
+func isRetryable(req *http.Request, resp *http.Response) bool {
+	if resp == nil {
+		return isIdempotent(req.Method)
+	}
+	switch resp.StatusCode {
+	case http.StatusLocked, http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+func honorsRetryAfter(code int) bool {
+	return code == http.StatusLocked || code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+func isIdempotent(method string) bool {
+	return method != http.MethodPost && method != http.MethodPatch
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fastRetryPolicy(maxRetries int) *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{
+		MaxRetries:      maxRetries,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+		Multiplier:      2,
+	}
+}
+
+/*
+ Serves the given status codes in order, repeating the last one once the script is exhausted.
+*/
+type scriptedHandler struct {
+	sync.Mutex
+	statuses []int
+	headers  map[int]http.Header
+	body     string
+	requests []*http.Request
+}
+
+func (h *scriptedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+
+	i := len(h.requests)
+	if i >= len(h.statuses) {
+		i = len(h.statuses) - 1
+	}
+	h.requests = append(h.requests, r)
+
+	status := h.statuses[i]
+	for k, v := range h.headers[len(h.requests)] {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+	if status == 200 {
+		fmt.Fprintln(w, h.body)
+	} else {
+		fmt.Fprintf(w, `{"error": "status %d"}`, status)
+	}
+}
+
+func (h *scriptedHandler) calls() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.requests)
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(5)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &scriptedHandler{statuses: []int{423, 429, 503, 200}, body: vmJson}
+	server.Config.Handler = handler
+
+	vm, err := GetVirtualMachine(client, "1001")
+	require.NoError(t, err, "Should succeed after retries")
+	require.Equal(t, "1001", vm.Id, "Should return the result of the successful attempt")
+	require.Equal(t, 4, handler.calls())
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(2)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &scriptedHandler{statuses: []int{423}}
+	server.Config.Handler = handler
+
+	_, err := GetVirtualMachine(client, "1001")
+	require.True(t, IsBusy(err), "Should return the last busy error")
+	require.Equal(t, 3, handler.calls(), "Should make the initial attempt plus MaxRetries")
+}
+
+func TestRetryNotOnClientError(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(5)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &scriptedHandler{statuses: []int{404}}
+	server.Config.Handler = handler
+
+	_, err := GetVirtualMachine(client, "1001")
+	require.True(t, IsNotFound(err))
+	require.Equal(t, 1, handler.calls())
+}
+
+func TestRetryPostOnlyWhenRejected(t *testing.T) {
+	envJson := readJson(t, "testdata/environment-1.json")
+
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(5)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &scriptedHandler{statuses: []int{500, 200}, body: envJson}
+	server.Config.Handler = handler
+
+	_, err := CreateNewEnvironment(client, "2")
+	require.Error(t, err, "POST should not be retried after a server error")
+	require.Equal(t, 1, handler.calls())
+
+	handler = &scriptedHandler{statuses: []int{429, 423, 200}, body: envJson}
+	server.Config.Handler = handler
+
+	env, err := CreateNewEnvironment(client, "2")
+	require.NoError(t, err, "POST should be retried when rejected")
+	require.Equal(t, "Environment 1", env.Name)
+	require.Equal(t, 3, handler.calls())
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(5)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var bodies []string
+	handler := &scriptedHandler{statuses: []int{423, 200}, body: "{}"}
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		bodies = append(bodies, string(body))
+		handler.ServeHTTP(w, r)
+	})
+
+	_, err := RenameEnvironment(client, "1", "renamed", false)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(bodies))
+	require.Equal(t, bodies[0], bodies[1], "Each attempt should send the full body")
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	policy := fastRetryPolicy(5)
+	req, _ := http.NewRequest("GET", "https://cloud.skytap.com/vms/1", nil)
+
+	for _, code := range []int{423, 429, 503} {
+		resp := &http.Response{StatusCode: code, Header: http.Header{"Retry-After": []string{"7"}}}
+		wait, retry := policy.NextRetry(1, 0, req, resp, nil)
+		require.True(t, retry)
+		require.Equal(t, 7*time.Second, wait, "Should use Retry-After for %d", code)
+	}
+
+	resp := &http.Response{StatusCode: 500, Header: http.Header{"Retry-After": []string{"7"}}}
+	wait, retry := policy.NextRetry(1, 0, req, resp, nil)
+	require.True(t, retry)
+	require.Equal(t, time.Millisecond, wait, "Should ignore Retry-After for 500")
+}
+
+func TestRetryMaxElapsedTime(t *testing.T) {
+	policy := fastRetryPolicy(5)
+	policy.MaxElapsedTime = 10 * time.Second
+	req, _ := http.NewRequest("GET", "https://cloud.skytap.com/vms/1", nil)
+	resp := &http.Response{StatusCode: 423, Header: http.Header{"Retry-After": []string{"30"}}}
+
+	_, retry := policy.NextRetry(1, 0, req, resp, nil)
+	require.False(t, retry, "Should not wait beyond MaxElapsedTime")
+}
+
+func TestBackoffGrowth(t *testing.T) {
+	policy := &BackoffRetryPolicy{MaxRetries: 10, InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+	require.Equal(t, time.Second, policy.backoff(1))
+	require.Equal(t, 2*time.Second, policy.backoff(2))
+	require.Equal(t, 4*time.Second, policy.backoff(3))
+	require.Equal(t, 5*time.Second, policy.backoff(4), "Should be capped by MaxInterval")
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		d := policy.backoff(2)
+		require.True(t, d >= time.Second && d <= 3*time.Second, "Jittered delay out of range: %s", d)
+	}
+}
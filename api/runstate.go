@@ -0,0 +1,95 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "fmt"
+
+/*
+ Helper type for checking runstate changes, see CheckRunstateChange. Resource fields and the runstate parameters of
+ the ChangeRunstate methods are plain strings; convert them with Runstate(s) to use these methods. The RunState
+ constants are untyped, so they can be used as either.
+
+ Resources are seen in the running, stopped, suspended and busy runstates. halted and reset can only be requested,
+ and leave the resource stopped and running respectively.
+*/
+type Runstate string
+
+/*
+ Runstates that may be requested from each runstate a resource can be in. The same rules apply to VMs and
+ environments; requesting the runstate a resource is already in is allowed and has no effect. Nothing may be requested
+ while a resource is busy.
+*/
+var runstateTransitions = map[Runstate][]Runstate{
+	RunStateStart: {RunStateStart, RunStateStop, RunStatePause, RunStateKill, RunStateReset},
+	RunStateStop:  {RunStateStart, RunStateStop, RunStateKill},
+	RunStatePause: {RunStateStart, RunStatePause},
+}
+
+/*
+ True while a resource is changing runstate, when no other change can be requested.
+*/
+func (r Runstate) IsTransitional() bool {
+	return r == RunStateBusy
+}
+
+/*
+ Runstate a resource ends up in once the given runstate has been requested for it.
+*/
+func (r Runstate) Result() Runstate {
+	switch r {
+	case RunStateKill:
+		return RunStateStop
+	case RunStateReset:
+		return RunStateStart
+	}
+	return r
+}
+
+/*
+ Whether the requested runstate may be asked for while a resource is in this one.
+*/
+func (r Runstate) CanChangeTo(requested Runstate) bool {
+	for _, allowed := range runstateTransitions[r] {
+		if allowed == requested {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ Error for a runstate change that isn't allowed from the resource's current runstate.
+*/
+type RunstateTransitionError struct {
+	From Runstate
+	To   Runstate
+}
+
+func (e *RunstateTransitionError) Error() string {
+	if e.From.IsTransitional() {
+		return fmt.Sprintf("Unable to change runstate to %s while %s", e.To, e.From)
+	}
+	return fmt.Sprintf("Unable to change runstate from %s to %s", e.From, e.To)
+}
+
+/*
+ Returns a *RunstateTransitionError if requested can't be asked for from current, otherwise nil.
+*/
+func CheckRunstateChange(current Runstate, requested Runstate) error {
+	if !current.CanChangeTo(requested) {
+		return &RunstateTransitionError{From: current, To: requested}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunstateTransitions(t *testing.T) {
+	require.True(t, Runstate(RunStateStart).CanChangeTo(RunStateStop))
+	require.True(t, Runstate(RunStateStart).CanChangeTo(RunStateReset))
+	require.True(t, Runstate(RunStateStop).CanChangeTo(RunStateKill))
+	require.True(t, Runstate(RunStatePause).CanChangeTo(RunStateStart))
+
+	require.False(t, Runstate(RunStatePause).CanChangeTo(RunStateStop))
+	require.False(t, Runstate(RunStatePause).CanChangeTo(RunStateKill))
+	require.False(t, Runstate(RunStateStop).CanChangeTo(RunStatePause))
+	require.False(t, Runstate(RunStateStop).CanChangeTo(RunStateReset))
+	require.False(t, Runstate(RunStateBusy).CanChangeTo(RunStateStart))
+	require.False(t, Runstate(RunStateStart).CanChangeTo("sleeping"))
+}
+
+func TestRunstateHelpers(t *testing.T) {
+	require.True(t, Runstate(RunStateBusy).IsTransitional())
+	require.False(t, Runstate(RunStateStart).IsTransitional())
+
+	require.Equal(t, Runstate(RunStateStop), Runstate(RunStateKill).Result())
+	require.Equal(t, Runstate(RunStateStart), Runstate(RunStateReset).Result())
+	require.Equal(t, Runstate(RunStatePause), Runstate(RunStatePause).Result())
+}
+
+func TestCheckRunstateChange(t *testing.T) {
+	require.NoError(t, CheckRunstateChange(RunStateStart, RunStatePause))
+	require.EqualError(t, CheckRunstateChange(RunStatePause, RunStateStop), "Unable to change runstate from suspended to stopped")
+	require.EqualError(t, CheckRunstateChange(RunStateBusy, RunStateStop), "Unable to change runstate to stopped while busy")
+}
+
+func TestStopSuspendedVm(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method, "No runstate change should be requested")
+		fmt.Fprintln(w, strings.Replace(vmJson, "stopped", "suspended", 1))
+	})
+
+	_, err := (&VirtualMachine{Id: "1001"}).Stop(client)
+	transitionErr, ok := err.(*RunstateTransitionError)
+	require.True(t, ok)
+	require.Equal(t, Runstate(RunStatePause), transitionErr.From)
+	require.Equal(t, Runstate(RunStateStop), transitionErr.To)
+}
@@ -0,0 +1,184 @@
+package api_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skytap/skytap-sdk-go/api"
+	"github.com/skytap/skytap-sdk-go/skytaptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentStopKillReset(t *testing.T) {
+	server, client := skytaptest.NewTestServer(t)
+
+	template := server.AddTemplate("Golden image", "US-West", "web", "db")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	webId, dbId := env.Vms[0].Id, env.Vms[1].Id
+	server.SetToolsLoaded(dbId, false)
+
+	env, err = env.Start(client)
+	require.NoError(t, err)
+	env, err = env.Reset(client)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, env.Runstate)
+
+	env, err = env.Stop(client)
+	require.Error(t, err)
+	runstateErr, ok := err.(*api.VmRunstateError)
+	require.True(t, ok, "Should report the VMs that didn't stop")
+	require.Equal(t, []string{dbId}, keys(runstateErr.Vms))
+	require.Contains(t, runstateErr.Vms[dbId], "VMware Tools")
+	require.Equal(t, api.RunStateStop, server.VirtualMachine(webId).Runstate)
+	require.Equal(t, api.RunStateStart, server.VirtualMachine(dbId).Runstate)
+
+	env, err = env.Kill(client)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStop, env.Runstate)
+
+	_, err = env.Reset(client)
+	require.Error(t, err, "Resetting a stopped environment should be refused")
+
+	env, err = env.Start(client)
+	require.NoError(t, err)
+	env, err = env.Suspend(client)
+	require.NoError(t, err)
+	_, err = env.Stop(client)
+	require.EqualError(t, err, "Unable to stop a suspended environment.")
+}
+
+func TestRestart(t *testing.T) {
+	server, client := skytaptest.NewTestServer(t)
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	vm := env.Vms[0]
+
+	result, err := vm.Restart(client, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, api.RestartStarted, result.Method, "A stopped VM should just be started")
+	require.Equal(t, api.RunStateStart, result.Vm.Runstate)
+
+	result, err = result.Vm.Restart(client, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, api.RestartGraceful, result.Method)
+	require.Nil(t, result.ShutdownError)
+	require.Equal(t, api.RunStateStart, result.Vm.Runstate)
+
+	server.SetToolsLoaded(vm.Id, false)
+	result, err = result.Vm.Restart(client, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, api.RestartForced, result.Method, "A VM without tools should be killed")
+	require.Error(t, result.ShutdownError)
+	require.Equal(t, api.RunStateStart, result.Vm.Runstate)
+}
+
+func TestRestartShutdownIgnored(t *testing.T) {
+	server, client := skytaptest.NewTestServer(t)
+	server.BusyPolls = 2
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	vm, err := env.Vms[0].Start(client)
+	require.NoError(t, err)
+
+	server.SetShutdownIgnored(vm.Id, true)
+	result, err := vm.Restart(client, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, api.RestartForced, result.Method, "A VM still running after the shutdown should be killed")
+	require.Contains(t, result.ShutdownError.Error(), "still running")
+	require.Equal(t, api.RunStateStart, result.Vm.Runstate)
+}
+
+func TestBulkRunstates(t *testing.T) {
+	server, client := skytaptest.NewTestServer(t)
+	server.BusyPolls = 2
+
+	template := server.AddTemplate("Golden image", "US-West", "web", "db")
+	var vms []*api.VirtualMachine
+	for i := 0; i < 3; i++ {
+		env, err := api.CreateNewEnvironment(client, template.Id)
+		require.NoError(t, err)
+		vms = append(vms, env.Vms...)
+	}
+
+	results, err := api.ChangeVirtualMachineRunstates(client, vms, api.RunStateStart, 4)
+	require.NoError(t, err)
+	require.Equal(t, 6, len(results))
+	for _, vm := range vms {
+		require.Equal(t, api.RunStateStart, results[vm.Id].Vm.Runstate)
+		require.Equal(t, api.RunStateStart, server.VirtualMachine(vm.Id).Runstate)
+	}
+
+	_, err = api.ChangeVirtualMachineRunstatesById(client, []string{vms[0].Id, vms[1].Id}, api.RunStatePause, 0)
+	require.NoError(t, err)
+	results, err = api.ChangeVirtualMachineRunstatesById(client, []string{vms[0].Id, vms[2].Id}, api.RunStateStop, 0)
+	require.Error(t, err, "Stopping a suspended VM should fail")
+	require.IsType(t, &api.RunstateTransitionError{}, results[vms[0].Id].Err)
+	require.NoError(t, results[vms[2].Id].Err, "Other VMs should still be changed")
+	require.Equal(t, api.RunStateStop, server.VirtualMachine(vms[2].Id).Runstate)
+}
+
+func TestAsyncOperations(t *testing.T) {
+	server, client := skytaptest.NewTestServer(t)
+	server.BusyPolls = 2
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	var creating []*api.Operation[*api.Environment]
+	for i := 0; i < 3; i++ {
+		creating = append(creating, api.CreateNewEnvironmentAsync(client, template.Id))
+	}
+
+	var starting []*api.Operation[*api.Environment]
+	for _, op := range creating {
+		env, err := op.Wait(context.Background())
+		require.NoError(t, err)
+		starting = append(starting, env.StartAsync(client))
+	}
+	for _, op := range starting {
+		env, err := op.Wait(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, api.RunStateStart, env.Runstate)
+		require.Equal(t, api.OperationSucceeded, op.Status())
+	}
+
+	env, _ := starting[0].Result()
+	vm, err := env.Vms[0].Stop(client)
+	require.NoError(t, err)
+	vm, err = vm.AddDiskAsync(client, env.Id, 2048, true).Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, vm.Runstate)
+}
+
+func TestSuspendPolicyViolations(t *testing.T) {
+	server, client := skytaptest.NewTestServer(t)
+
+	compliant := server.AddEnvironment("Idle suspend")
+	_, err := api.UpdateEnvironment(client, compliant.Id, &api.EnvironmentUpdate{SuspendOnIdle: api.Int(1800)})
+	require.NoError(t, err)
+	scheduled, err := server.AddEnvironment("Scheduled").ScheduleSuspend(client, 20, 0, time.UTC)
+	require.NoError(t, err)
+	at, ok, err := scheduled.SuspendAt()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, at.After(time.Now()) && at.Before(time.Now().Add(24*time.Hour)))
+	violating := server.AddEnvironment("Always on")
+
+	policy := &api.SuspendPolicy{MaxIdle: time.Hour, SuspendBy: 20 * time.Hour, Location: time.UTC}
+	violations, err := policy.Violations(client, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(violations))
+	require.Equal(t, violating.Id, violations[0].Environment.Id)
+}
+
+func keys(m map[string]string) []string {
+	var result []string
+	for k := range m {
+		result = append(result, k)
+	}
+	return result
+}
@@ -0,0 +1,198 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Format of an environment's suspend_at_time and shutdown_at_time.
+	SuspendAtTimeLayout = "2006/01/02 15:04:05 -0700"
+
+	// Range skytap accepts for suspend_on_idle.
+	MinSuspendOnIdle = 5 * time.Minute
+	MaxSuspendOnIdle = 24 * time.Hour
+)
+
+/*
+ How long the environment may be idle before it is suspended, and whether it is suspended when idle at all.
+*/
+func (e *Environment) SuspendOnIdleDuration() (time.Duration, bool) {
+	if e.SuspendOnIdle == nil || *e.SuspendOnIdle <= 0 {
+		return 0, false
+	}
+	return time.Duration(*e.SuspendOnIdle) * time.Second, true
+}
+
+/*
+ When the environment is scheduled to be suspended, and whether it is. An error is returned if skytap's value can't
+ be parsed.
+*/
+func (e *Environment) SuspendAt() (time.Time, bool, error) {
+	if e.SuspendAtTime == nil || *e.SuspendAtTime == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(SuspendAtTimeLayout, *e.SuspendAtTime)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("Unable to parse suspend_at_time '%s' of environment %s: %s", *e.SuspendAtTime, e.Id, err)
+	}
+	return t, true, nil
+}
+
+/*
+ Suspend the environment after it has been idle for d, or never if d is zero. d is rounded to the second and must be
+ between MinSuspendOnIdle and MaxSuspendOnIdle.
+*/
+func (u *EnvironmentUpdate) SetSuspendOnIdle(d time.Duration) error {
+	if d != 0 && (d < MinSuspendOnIdle || d > MaxSuspendOnIdle) {
+		return fmt.Errorf("Idle suspend time %s must be between %s and %s", d, MinSuspendOnIdle, MaxSuspendOnIdle)
+	}
+	u.SuspendOnIdle = Int(int(d.Round(time.Second) / time.Second))
+	return nil
+}
+
+/*
+ Suspend the environment at t, in t's time zone, or never if t is the zero time.
+*/
+func (u *EnvironmentUpdate) SetSuspendAt(t time.Time) {
+	if t.IsZero() {
+		u.SuspendAtTime = String("")
+		return
+	}
+	u.SuspendAtTime = String(t.Format(SuspendAtTimeLayout))
+}
+
+/*
+ The first time after now that the clock in loc reads hour:minute. On days when daylight saving time skips that
+ time, the time it is normalized to is returned.
+*/
+func NextSuspendTime(now time.Time, hour int, minute int, loc *time.Location) time.Time {
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, loc)
+	}
+	return next
+}
+
+/*
+ Schedule the environment to be suspended the next time the clock in loc reads hour:minute.
+*/
+func (e *Environment) ScheduleSuspend(client SkytapClient, hour int, minute int, loc *time.Location) (*Environment, error) {
+	at := NextSuspendTime(time.Now(), hour, minute, loc)
+	client.logger().Info("Scheduling environment suspend", "envId", e.Id, "suspendAt", at)
+
+	update := &EnvironmentUpdate{}
+	update.SetSuspendAt(at)
+	return e.Update(client, update)
+}
+
+/*
+ A cost policy environments must follow, such as suspending after 60 minutes idle or at 20:00 local time. An
+ environment complies if it meets any of the policy's rules; a policy without rules is met by every environment.
+*/
+type SuspendPolicy struct {
+	// Environments comply if they're suspended after being idle for at most this long. Zero disables the rule.
+	MaxIdle time.Duration
+	// Environments comply if they're scheduled to be suspended no later than the next time the clock in Location
+	// reads this time of day, given as the time since midnight (20 * time.Hour for 20:00, 24 * time.Hour for
+	// midnight). Zero disables the rule.
+	SuspendBy time.Duration
+	// Time zone of the suspend by time, UTC if nil.
+	Location *time.Location
+}
+
+/*
+ An environment that doesn't comply with a SuspendPolicy.
+*/
+type SuspendPolicyViolation struct {
+	Environment *Environment
+	Reason      string
+}
+
+func (v *SuspendPolicyViolation) Error() string {
+	return fmt.Sprintf("Environment %s (%s) %s", v.Environment.Id, v.Environment.Name, v.Reason)
+}
+
+/*
+ Returns a *SuspendPolicyViolation if env doesn't comply with the policy at the given time, otherwise nil.
+*/
+func (p *SuspendPolicy) Check(env *Environment, now time.Time) error {
+	if p.MaxIdle <= 0 && p.SuspendBy <= 0 {
+		return nil
+	}
+
+	idle, suspendsWhenIdle := env.SuspendOnIdleDuration()
+	if p.MaxIdle > 0 && suspendsWhenIdle && idle <= p.MaxIdle {
+		return nil
+	}
+
+	at, scheduled, err := env.SuspendAt()
+	if err != nil {
+		return &SuspendPolicyViolation{Environment: env, Reason: err.Error()}
+	}
+	var deadline time.Time
+	if p.SuspendBy > 0 {
+		loc := p.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+		deadline = NextSuspendTime(now, int(p.SuspendBy/time.Hour), int(p.SuspendBy%time.Hour/time.Minute), loc)
+		if scheduled && at.After(now) && !at.After(deadline) {
+			return nil
+		}
+	}
+
+	var reason string
+	switch {
+	case suspendsWhenIdle && p.MaxIdle > 0:
+		reason = fmt.Sprintf("suspends after %s idle, more than %s", idle, p.MaxIdle)
+	case p.MaxIdle > 0:
+		reason = "isn't suspended when idle"
+	}
+	if p.SuspendBy > 0 {
+		if reason != "" {
+			reason += " and "
+		}
+		if scheduled {
+			reason += fmt.Sprintf("is scheduled to suspend at %s, not by %s", at.Format(SuspendAtTimeLayout), deadline.Format(SuspendAtTimeLayout))
+		} else {
+			reason += fmt.Sprintf("isn't scheduled to suspend by %s", deadline.Format(SuspendAtTimeLayout))
+		}
+	}
+	return &SuspendPolicyViolation{Environment: env, Reason: reason}
+}
+
+/*
+ Finds the environments matching filter that don't comply with the policy. Each environment is fetched in full to
+ read its suspend settings.
+*/
+func (p *SuspendPolicy) Violations(client SkytapClient, filter *EnvironmentFilter) ([]*SuspendPolicyViolation, error) {
+	var violations []*SuspendPolicyViolation
+	now := time.Now()
+	err := ListEnvironments(client, filter, nil, func(listed *Environment) error {
+		env, err := GetEnvironment(client, listed.Id)
+		if err != nil {
+			return err
+		}
+		if err = p.Check(env, now); err != nil {
+			violations = append(violations, err.(*SuspendPolicyViolation))
+		}
+		return nil
+	})
+	return violations, err
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var pacific = time.FixedZone("PDT", -7*60*60)
+
+func TestNextSuspendTime(t *testing.T) {
+	now := time.Date(2026, 10, 17, 15, 30, 0, 0, pacific)
+	require.Equal(t, time.Date(2026, 10, 17, 20, 0, 0, 0, pacific), NextSuspendTime(now, 20, 0, pacific))
+	require.Equal(t, time.Date(2026, 10, 18, 9, 15, 0, 0, pacific), NextSuspendTime(now, 9, 15, pacific), "Times already passed today should be tomorrow")
+	require.Equal(t, time.Date(2026, 10, 18, 15, 30, 0, 0, pacific), NextSuspendTime(now, 15, 30, pacific))
+
+	// 15:30 in PDT is 22:30 UTC, so 20:00 UTC has passed.
+	require.Equal(t, time.Date(2026, 10, 18, 20, 0, 0, 0, time.UTC), NextSuspendTime(now, 20, 0, time.UTC))
+}
+
+func TestNextSuspendTimeDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	// Clocks go forward from 02:00 to 03:00 on 2026-03-08.
+	now := time.Date(2026, 3, 7, 21, 0, 0, 0, loc)
+	next := NextSuspendTime(now, 20, 0, loc)
+	require.Equal(t, time.Date(2026, 3, 8, 20, 0, 0, 0, loc), next)
+	require.Equal(t, 22*time.Hour, next.Sub(now), "The day clocks go forward is an hour short")
+}
+
+func TestSuspendSettings(t *testing.T) {
+	env := &Environment{Id: "1"}
+	_, ok := env.SuspendOnIdleDuration()
+	require.False(t, ok)
+	_, ok, err := env.SuspendAt()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	update := &EnvironmentUpdate{}
+	require.NoError(t, update.SetSuspendOnIdle(time.Hour))
+	require.Equal(t, 3600, *update.SuspendOnIdle)
+	require.Error(t, update.SetSuspendOnIdle(time.Minute))
+	require.Error(t, update.SetSuspendOnIdle(48*time.Hour))
+	require.NoError(t, update.SetSuspendOnIdle(0))
+	require.Equal(t, 0, *update.SuspendOnIdle)
+
+	at := time.Date(2026, 10, 17, 20, 0, 0, 0, pacific)
+	update.SetSuspendAt(at)
+	require.Equal(t, "2026/10/17 20:00:00 -0700", *update.SuspendAtTime)
+
+	env.SuspendOnIdle = Int(1800)
+	env.SuspendAtTime = update.SuspendAtTime
+	idle, ok := env.SuspendOnIdleDuration()
+	require.True(t, ok)
+	require.Equal(t, 30*time.Minute, idle)
+	parsed, ok, err := env.SuspendAt()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, at.Equal(parsed))
+
+	update.SetSuspendAt(time.Time{})
+	require.Equal(t, "", *update.SuspendAtTime)
+
+	env.SuspendAtTime = String("tonight")
+	_, _, err = env.SuspendAt()
+	require.Error(t, err)
+}
+
+func TestSuspendPolicy(t *testing.T) {
+	policy := &SuspendPolicy{MaxIdle: time.Hour, SuspendBy: 20 * time.Hour, Location: pacific}
+	now := time.Date(2026, 10, 17, 15, 30, 0, 0, pacific)
+
+	require.NoError(t, policy.Check(&Environment{SuspendOnIdle: Int(3600)}, now))
+	require.NoError(t, policy.Check(&Environment{SuspendAtTime: String("2026/10/17 19:00:00 -0700")}, now))
+	require.NoError(t, policy.Check(&Environment{SuspendOnIdle: Int(7200), SuspendAtTime: String("2026/10/18 02:00:00 +0000")}, now),
+		"Either rule should be enough")
+
+	err := policy.Check(&Environment{Id: "1", Name: "Build"}, now)
+	require.EqualError(t, err, "Environment 1 (Build) isn't suspended when idle and isn't scheduled to suspend by 2026/10/17 20:00:00 -0700")
+
+	err = policy.Check(&Environment{Id: "1", Name: "Build", SuspendOnIdle: Int(7200), SuspendAtTime: String("2026/10/17 21:00:00 -0700")}, now)
+	violation, ok := err.(*SuspendPolicyViolation)
+	require.True(t, ok)
+	require.Equal(t, "suspends after 2h0m0s idle, more than 1h0m0s and is scheduled to suspend at 2026/10/17 21:00:00 -0700, not by 2026/10/17 20:00:00 -0700", violation.Reason)
+
+	require.Error(t, policy.Check(&Environment{SuspendAtTime: String("2026/10/17 12:00:00 -0700")}, now), "A suspend time that has passed should not count")
+
+	idleOnly := &SuspendPolicy{MaxIdle: time.Hour}
+	require.EqualError(t, idleOnly.Check(&Environment{Id: "2", Name: "Test", SuspendAtTime: String("2026/10/17 19:00:00 -0700")}, now),
+		"Environment 2 (Test) isn't suspended when idle")
+	require.NoError(t, (&SuspendPolicy{}).Check(&Environment{}, now))
+}
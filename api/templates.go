@@ -14,6 +14,13 @@
 
 package api
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/dghubble/sling"
+)
+
 const (
 	TemplatePath = "templates"
 )
@@ -22,8 +29,301 @@ const (
  Skytap template resource.
 */
 type Template struct {
-	Id     string `json:"id"`
-	Url    string `json:"url"`
-	Name   string `json:"name"`
-	Region string `json:"region"`
+	Id                  string            `json:"id"`
+	Url                 string            `json:"url"`
+	Name                string            `json:"name"`
+	Region              string            `json:"region"`
+	RegionBackend       string            `json:"region_backend,omitempty"`
+	Description         string            `json:"description,omitempty"`
+	Public              bool              `json:"public"`
+	Busy                *bool             `json:"busy,omitempty"`
+	LockVersion         string            `json:"lockversion,omitempty"`
+	Shared              bool              `json:"shared,omitempty"`
+	ContainersCount     int               `json:"containers_count"`
+	ContainerHostsCount int               `json:"container_hosts_count"`
+	TagList             string            `json:"tag_list,omitempty"`
+	Vms                 []*VirtualMachine `json:"vms,omitempty"`
+	Networks            []Network         `json:"networks,omitempty"`
+	VmCount             int               `json:"vm_count,omitempty"`
+}
+
+/*
+ True while the template is being created or changed, when it can't be used or modified.
+*/
+func (t *Template) IsBusy() bool {
+	return t.Busy != nil && *t.Busy
+}
+
+/*
+ Wait until the template is no longer busy, returning a freshly fetched representation.
+*/
+func (t *Template) WaitUntilReady(client SkytapClient) (*Template, error) {
+	client.logger().Info("Waiting until template is not busy", "templateId", t.Id)
+	start := time.Now()
+
+	current := t
+	err := client.poll(func(client SkytapClient) (bool, error) {
+		refreshed, err := GetTemplate(client, t.Id)
+		if err != nil {
+			return false, err
+		}
+		current = refreshed
+		return !current.IsBusy(), nil
+	})
+	if err == errWaitTimeout {
+		waited := time.Since(start)
+		err = &WaitTimeoutError{Waited: waited, message: fmt.Sprintf("Template %s still busy after %d seconds", t.Id, waited/time.Second)}
+	}
+	return current, err
+}
+
+/*
+ WaitUntilReady, polling as opts say instead of the client's WaitOptions. If opts is nil the client's are used.
+*/
+func (t *Template) WaitUntilReadyWithOptions(client SkytapClient, opts *WaitOptions) (*Template, error) {
+	return t.WaitUntilReady(client.withCallWaitOptions(opts))
+}
+
+/*
+ Criteria for ListTemplates, empty fields are not filtered on. Values must not contain commas or colons.
+*/
+type TemplateFilter struct {
+	// Templates whose name contains this text.
+	Name   string
+	Region string
+	// ScopeMe for the caller's own templates (the API default), ScopeCompany for every template visible to the caller,
+	// or ScopePublic for the public template library.
+	Scope string
+}
+
+type templateListQuery struct {
+	Scope string `url:"scope,omitempty"`
+	Query string `url:"query,omitempty"`
+}
+
+func (f *TemplateFilter) listQuery() (*templateListQuery, error) {
+	query := &templateListQuery{}
+	if f == nil {
+		return query, nil
+	}
+	query.Scope = f.Scope
+
+	var err error
+	query.Query, err = listQuery(
+		listQueryTerm{key: "name", value: f.Name},
+		listQueryTerm{key: "region", value: f.Region},
+	)
+	return query, err
+}
+
+/*
+ Request body for creating a template from an environment.
+*/
+type CreateTemplateBody struct {
+	EnvironmentId string   `json:"configuration_id"`
+	VmIds         []string `json:"vm_instance_ids,omitempty"`
+}
+
+/*
+ Request body for template changes, nil fields are left unchanged.
+*/
+type TemplateUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Public      *bool   `json:"public,omitempty"`
+	Shared      *bool   `json:"shared,omitempty"`
+	// If set, the update fails with a conflict (see IsConflict) unless the template is still at this version.
+	LockVersion string `json:"lockversion,omitempty"`
+}
+
+/*
+ Request body for merging environment VMs into a template.
+*/
+type MergeIntoTemplateBody struct {
+	EnvironmentId string   `json:"merge_configuration"`
+	VmIds         []string `json:"vm_ids,omitempty"`
+	LockVersion   string   `json:"lockversion,omitempty"`
+}
+
+type lockVersionQuery struct {
+	LockVersion string `url:"lockversion,omitempty"`
+}
+
+func templateIdPath(templateId string) string { return TemplatePath + "/" + templateId + ".json" }
+
+/*
+ Return an existing template by id.
+*/
+func GetTemplate(client SkytapClient, templateId string) (*Template, error) {
+	template := &Template{}
+
+	getTemplate := func(s *sling.Sling) *sling.Sling {
+		return s.Get(templateIdPath(templateId))
+	}
+
+	_, err := RunSkytapRequest(client, true, template, getTemplate)
+	return template, err
+}
+
+/*
+ Calls fn with each template matching the filter, paged as given by opts. A nil filter lists all of the caller's
+ templates.
+
+ Listed templates are summaries, their VMs and networks aren't included; use GetTemplate for the full resource.
+*/
+func ListTemplates(client SkytapClient, filter *TemplateFilter, opts *ListOptions, fn func(template *Template) error) error {
+	query, err := filter.listQuery()
+	if err != nil {
+		return err
+	}
+	listTemplates := func(s *sling.Sling) *sling.Sling {
+		return s.Get(TemplatePath + ".json").QueryStruct(query)
+	}
+	return ListEach(client, true, opts, listTemplates, fn)
+}
+
+/*
+ Return the template with exactly the given name, searching the given scope. It is an error if there is no such
+ template, or more than one.
+*/
+func GetTemplateByName(client SkytapClient, name string, scope string) (*Template, error) {
+	var found []*Template
+	err := ListTemplates(client, &TemplateFilter{Name: name, Scope: scope}, nil, func(template *Template) error {
+		if template.Name == name {
+			found = append(found, template)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no template named %q", name)
+	case 1:
+		return GetTemplate(client, found[0].Id)
+	default:
+		return nil, fmt.Errorf("%d templates are named %q", len(found), name)
+	}
+}
+
+/*
+ Change a template's name, description or sharing.
+*/
+func UpdateTemplate(client SkytapClient, templateId string, update *TemplateUpdate) (*Template, error) {
+	client.logger().Info("Updating template", "templateId", templateId)
+
+	updateTemplate := func(s *sling.Sling) *sling.Sling {
+		return s.Put(templateIdPath(templateId)).BodyJSON(update)
+	}
+
+	template := &Template{}
+	_, err := RunSkytapRequest(client, true, template, updateTemplate)
+	return template, err
+}
+
+/*
+ Delete a template by id. If lockVersion is set, the delete fails with a conflict (see IsConflict) unless the template is
+ still at that version.
+*/
+func DeleteTemplate(client SkytapClient, templateId string, lockVersion string) error {
+	client.logger().Info("Deleting template", "templateId", templateId)
+
+	deleteTemplate := func(s *sling.Sling) *sling.Sling {
+		return s.Delete(templateIdPath(templateId)).QueryStruct(&lockVersionQuery{LockVersion: lockVersion})
+	}
+
+	_, err := RunSkytapRequest(client, true, nil, deleteTemplate)
+	return err
+}
+
+/*
+ Change this template, once it isn't busy. Unless update sets a LockVersion, the update only succeeds if the template
+ hasn't changed since this representation was fetched.
+*/
+func (t *Template) Update(client SkytapClient, update *TemplateUpdate) (*Template, error) {
+	if err := t.waitWhileBusy(client); err != nil {
+		return t, err
+	}
+	locked := *update
+	if locked.LockVersion == "" {
+		locked.LockVersion = t.LockVersion
+	}
+	return UpdateTemplate(client, t.Id, &locked)
+}
+
+/*
+ Delete this template, once it isn't busy. Fails with a conflict if the template has changed since this representation
+ was fetched.
+*/
+func (t *Template) Delete(client SkytapClient) error {
+	if err := t.waitWhileBusy(client); err != nil {
+		return err
+	}
+	return DeleteTemplate(client, t.Id, t.LockVersion)
+}
+
+/*
+ Merge VMs from an environment into this template, and wait until the template is ready. If vmIds is empty all of the
+ environment's VMs are merged. Fails with a conflict if the template has changed since this representation was fetched.
+*/
+func (t *Template) MergeEnvironmentVirtualMachines(client SkytapClient, envId string, vmIds []string) (*Template, error) {
+	return t.MergeVirtualMachine(client, &MergeIntoTemplateBody{EnvironmentId: envId, VmIds: vmIds, LockVersion: t.LockVersion})
+}
+
+/*
+ Merge VMs into this template, once it isn't busy, and wait until the template is ready again.
+
+ mergeBody - The correct representation of the request body, see MergeEnvironmentVirtualMachines.
+*/
+func (t *Template) MergeVirtualMachine(client SkytapClient, mergeBody interface{}) (*Template, error) {
+	client.logger().Info("Merging VMs into template", "mergeBody", mergeBody, "templateId", t.Id)
+	if err := t.waitWhileBusy(client); err != nil {
+		return t, err
+	}
+
+	merge := func(s *sling.Sling) *sling.Sling {
+		return s.Put(templateIdPath(t.Id)).BodyJSON(mergeBody)
+	}
+
+	merged := &Template{}
+	_, err := RunSkytapRequest(client, true, merged, merge)
+	if err != nil {
+		client.logger().Error("Unable to merge VMs into template", "templateId", t.Id, "requestBody", mergeBody, "error", err)
+		return t, err
+	}
+	return merged.WaitUntilReady(client)
+}
+
+/*
+ Remove a VM from this template, once it isn't busy, returning the updated template. Fails with a conflict if the
+ template has changed since this representation was fetched.
+*/
+func (t *Template) RemoveVirtualMachine(client SkytapClient, vmId string) (*Template, error) {
+	client.logger().Info("Removing VM from template", "vmId", vmId, "templateId", t.Id)
+	if err := t.waitWhileBusy(client); err != nil {
+		return t, err
+	}
+
+	removeVm := func(s *sling.Sling) *sling.Sling {
+		return s.Delete(vmIdInTemplatePath(t.Id, vmId)).QueryStruct(&lockVersionQuery{LockVersion: t.LockVersion})
+	}
+
+	if _, err := RunSkytapRequest(client, true, nil, removeVm); err != nil {
+		return t, err
+	}
+	return t.WaitUntilReady(client)
+}
+
+/*
+ Waits until the template isn't busy, without adopting the fresh lockversion, so that changes made by whoever made
+ the template busy are detected as conflicts.
+*/
+func (t *Template) waitWhileBusy(client SkytapClient) error {
+	if !t.IsBusy() {
+		return nil
+	}
+	_, err := t.WaitUntilReady(client)
+	return err
 }
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTemplate(t *testing.T) {
+	templateJson := readJson(t, "testdata/template-2.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method)
+		require.Equal(t, "/templates/2.json", r.URL.Path)
+		fmt.Fprintln(w, templateJson)
+	})
+
+	template, err := GetTemplate(client, "2")
+	require.NoError(t, err)
+	require.Equal(t, "Template with 2 VMs", template.Name)
+	require.Equal(t, "my template", template.Description)
+	require.Equal(t, "skytap", template.RegionBackend)
+	require.Equal(t, "5b12f669acf04d6761f00906e6d17576e6399148", template.LockVersion)
+	require.True(t, template.Public)
+	require.False(t, template.IsBusy())
+	require.Equal(t, 2, len(template.Vms))
+	require.Equal(t, "1002", template.Vms[0].Id)
+	require.Equal(t, 1, len(template.Networks))
+	require.Equal(t, "10.0.0.0/24", template.Networks[0].Subnet)
+}
+
+func TestListTemplates(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/templates.json", r.URL.Path)
+		require.Equal(t, ScopePublic, r.URL.Query().Get("scope"))
+		require.Equal(t, "name:Ubuntu,region:US-West", r.URL.Query().Get("query"))
+		fmt.Fprintln(w, `[{"id": "2", "name": "Ubuntu 14.04", "region": "US-West", "public": true, "vm_count": 1}]`)
+	})
+
+	var templates []*Template
+	filter := &TemplateFilter{Name: "Ubuntu", Region: "US-West", Scope: ScopePublic}
+	err := ListTemplates(client, filter, nil, func(template *Template) error {
+		templates = append(templates, template)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(templates))
+	require.Equal(t, 1, templates[0].VmCount)
+}
+
+func TestListTemplatesInvalidFilter(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request %s", r.URL)
+	})
+
+	err := ListTemplates(client, &TemplateFilter{Name: "Ubuntu,region:US-East"}, nil, func(template *Template) error { return nil })
+	require.Error(t, err)
+	_, err = GetTemplateByName(client, "Ubuntu: 14.04", ScopePublic)
+	require.Error(t, err)
+}
+
+func TestGetTemplateByName(t *testing.T) {
+	templateJson := readJson(t, "testdata/template-2.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	list := `[{"id": "2", "name": "Template with 2 VMs"}, {"id": "3", "name": "Template with 2 VMs (copy)"}]`
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/templates.json" {
+			fmt.Fprintln(w, list)
+		} else {
+			require.Equal(t, "/templates/2.json", r.URL.Path)
+			fmt.Fprintln(w, templateJson)
+		}
+	})
+
+	template, err := GetTemplateByName(client, "Template with 2 VMs", ScopeMe)
+	require.NoError(t, err, "Should ignore partial name matches")
+	require.Equal(t, 2, len(template.Vms), "Should return the full template")
+
+	_, err = GetTemplateByName(client, "Missing", ScopeMe)
+	require.Error(t, err)
+
+	list = `[{"id": "2", "name": "Twin"}, {"id": "3", "name": "Twin"}]`
+	_, err = GetTemplateByName(client, "Twin", ScopeMe)
+	require.Error(t, err, "Ambiguous names should be an error")
+}
+
+func TestTemplateUpdate(t *testing.T) {
+	templateJson := readJson(t, "testdata/template-2.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "PUT", r.Method)
+		require.Equal(t, "/templates/2.json", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		require.Equal(t, `{"name":"Renamed","public":false,"lockversion":"abc"}`, strings.TrimSpace(string(body)))
+		fmt.Fprintln(w, templateJson)
+	})
+
+	template := &Template{Id: "2", LockVersion: "abc"}
+	_, err := template.Update(client, &TemplateUpdate{Name: String("Renamed"), Public: Bool(false)})
+	require.NoError(t, err)
+}
+
+func TestTemplateUpdateConflict(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = fastRetryPolicy(3)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &scriptedHandler{statuses: []int{409}}
+	server.Config.Handler = handler
+
+	_, err := UpdateTemplate(client, "2", &TemplateUpdate{Description: String("new"), LockVersion: "stale"})
+	require.True(t, IsConflict(err))
+	require.Equal(t, 1, handler.calls(), "Conflicts should not be retried")
+}
+
+func TestDeleteTemplate(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "DELETE", r.Method)
+		require.Equal(t, "/templates/2.json", r.URL.Path)
+		_, hasLockVersion := r.URL.Query()["lockversion"]
+		require.False(t, hasLockVersion, "An empty lockversion should not be sent")
+	})
+
+	require.NoError(t, DeleteTemplate(client, "2", ""))
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "lv-2", r.URL.Query().Get("lockversion"))
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, `{"error": "Template has been changed"}`)
+	})
+
+	template := &Template{Id: "2", LockVersion: "lv-2"}
+	err := template.Delete(client)
+	require.True(t, IsConflict(err), "Template.Delete should send its lockversion")
+	require.False(t, IsBusy(err), "A conflict is not busy")
+}
+
+func TestTemplateMergeAndRemoveVms(t *testing.T) {
+	templateJson := readJson(t, "testdata/template-2.json")
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var requests []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		fmt.Fprintln(w, templateJson)
+	})
+
+	template := &Template{Id: "2", LockVersion: "abc"}
+	merged, err := template.MergeEnvironmentVirtualMachines(client, "1", []string{"1001"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(merged.Vms))
+
+	_, err = merged.RemoveVirtualMachine(client, "1003")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		`PUT /templates/2.json {"merge_configuration":"1","vm_ids":["1001"],"lockversion":"abc"}`,
+		"GET /templates/2.json",
+		"DELETE /templates/2/vms/1003.json?lockversion=5b12f669acf04d6761f00906e6d17576e6399148",
+		"GET /templates/2.json",
+	}, requests)
+}
@@ -15,15 +15,21 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/dghubble/sling"
 )
 
 const (
 	VmPath = "vms"
+
+	// How a VM was restarted, see RestartResult.
+	RestartGraceful = "graceful"
+	RestartForced   = "forced"
+	RestartStarted  = "started"
 )
 
 /*
@@ -123,6 +129,13 @@ func (vm *VirtualMachine) WaitUntilReady(client SkytapClient) (*VirtualMachine,
 	return vm.WaitUntilInState(client, []string{RunStateStop, RunStateStart, RunStatePause}, false)
 }
 
+/*
+ WaitUntilReady, polling as opts say instead of the client's WaitOptions. If opts is nil the client's are used.
+*/
+func (vm *VirtualMachine) WaitUntilReadyWithOptions(client SkytapClient, opts *WaitOptions) (*VirtualMachine, error) {
+	return vm.WaitUntilReady(client.withCallWaitOptions(opts))
+}
+
 /*
   Wait until the VM is in one of the desired states.
 */
@@ -136,7 +149,7 @@ func (vm *VirtualMachine) WaitUntilInState(client SkytapClient, desiredStates []
  Suspends a VM.
 */
 func (vm *VirtualMachine) Suspend(client SkytapClient) (*VirtualMachine, error) {
-	log.WithFields(log.Fields{"vmId": vm.Id}).Info("Suspending VM")
+	client.logger().Info("Suspending VM", "vmId", vm.Id)
 
 	return vm.ChangeRunstate(client, RunStatePause, RunStatePause)
 }
@@ -145,27 +158,17 @@ func (vm *VirtualMachine) Suspend(client SkytapClient) (*VirtualMachine, error)
  Starts a VM.
 */
 func (vm *VirtualMachine) Start(client SkytapClient) (*VirtualMachine, error) {
-	log.WithFields(log.Fields{"vmId": vm.Id}).Info("Starting VM")
+	client.logger().Info("Starting VM", "vmId", vm.Id)
 
 	return vm.ChangeRunstate(client, RunStateStart, RunStateStart)
 }
 
 /*
- Stops a VM. Note that some VMs may require user input and cannot be stopped with the method.
+ Stops a VM. Note that some VMs may require user input and cannot be stopped with the method. Suspended VMs can't be
+ stopped, see CheckRunstateChange.
 */
 func (vm *VirtualMachine) Stop(client SkytapClient) (*VirtualMachine, error) {
-	log.WithFields(log.Fields{"vmId": vm.Id}).Info("Stopping VM")
-
-	/*
-	 Need to check current machine state as transitioning from suspended to stopped is not valid.
-	*/
-	checkVm, err := GetVirtualMachine(client, vm.Id)
-	if err != nil {
-		return vm, err
-	}
-	if checkVm.Runstate == RunStatePause {
-		return vm, fmt.Errorf("Unable to stop a suspended VM.")
-	}
+	client.logger().Info("Stopping VM", "vmId", vm.Id)
 
 	/*
 		   There are cases where the call will succeed but the VM cannot be transitioned
@@ -188,21 +191,114 @@ func (vm *VirtualMachine) Stop(client SkytapClient) (*VirtualMachine, error) {
  Kills a VM forcefully.
 */
 func (vm *VirtualMachine) Kill(client SkytapClient) (*VirtualMachine, error) {
-	log.WithFields(log.Fields{"vmId": vm.Id}).Info("Killing VM")
+	client.logger().Info("Killing VM", "vmId", vm.Id)
 
 	return vm.ChangeRunstate(client, RunStateKill, RunStateStop)
 }
 
+/*
+ Power cycles a running VM, without shutting down its guest OS.
+*/
+func (vm *VirtualMachine) Reset(client SkytapClient) (*VirtualMachine, error) {
+	client.logger().Info("Resetting VM", "vmId", vm.Id)
+
+	ready, err := vm.WaitUntilReady(client)
+	if err != nil {
+		return ready, err
+	}
+	if err = CheckRunstateChange(Runstate(ready.Runstate), RunStateReset); err != nil {
+		return ready, err
+	}
+	changeState := func(s *sling.Sling) *sling.Sling {
+		return s.Put(vmIdPath(vm.Id)).BodyJSON(&RunstateBody{Runstate: RunStateReset})
+	}
+	_, err = RunSkytapRequest(client, false, nil, changeState)
+	if err != nil {
+		return ready, err
+	}
+
+	/*
+	 The VM ends up running as it was before the reset, so there's no change of runstate to wait for.
+	*/
+	return vm.WaitUntilInState(client, []string{RunStateStart}, false)
+}
+
+/*
+ Outcome of a Restart.
+*/
+type RestartResult struct {
+	// The VM, running again.
+	Vm *VirtualMachine
+	// RestartGraceful if the guest shut down in time, RestartForced if the VM had to be killed, or RestartStarted if it
+	// wasn't running and was only started.
+	Method string
+	// Why the graceful shutdown was given up on, nil unless Method is RestartForced.
+	ShutdownError error
+}
+
+/*
+ Restarts a VM, shutting its guest down gracefully if possible.
+
+ If the guest doesn't shut down within shutdownTimeout, for instance because VMware tools aren't loaded, or the VM is
+ still running once the shutdown request has finished, the VM is killed instead before being started again. A VM that
+ isn't running is just started.
+*/
+func (vm *VirtualMachine) Restart(client SkytapClient, shutdownTimeout time.Duration) (*RestartResult, error) {
+	client.logger().Info("Restarting VM", "vmId", vm.Id, "shutdownTimeout", shutdownTimeout)
+
+	current, err := vm.WaitUntilReady(client)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestartResult{Method: RestartStarted}
+	stopped := current
+	if current.Runstate == RunStateStart {
+		result.Method = RestartGraceful
+
+		ctx, cancel := context.WithTimeout(client.Context(), shutdownTimeout)
+		stopped, err = current.Stop(client.WithContext(ctx))
+		cancel()
+		if err == nil && stopped.Runstate != RunStateStop {
+			// Stop also accepts the VM still running, when its guest didn't shut down.
+			err = fmt.Errorf("VM %s is still %s after the shutdown request", vm.Id, stopped.Runstate)
+		}
+		if err != nil {
+			if client.Context().Err() != nil || IsNotFound(err) {
+				return nil, err
+			}
+			client.logger().Info("Graceful shutdown failed, killing VM", "vmId", vm.Id, "error", err)
+			result.Method = RestartForced
+			result.ShutdownError = err
+			if stopped, err = current.Kill(client); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	result.Vm, err = stopped.Start(client)
+	return result, err
+}
+
 /*
  Changes the runstate of the VM to the specified state and waits until the VM is in the desired state.
+
+ Changes that aren't allowed from the VM's runstate are refused with a *RunstateTransitionError, see
+ CheckRunstateChange. Nothing is requested if the VM is already in the runstate the change would lead to.
 */
 func (vm *VirtualMachine) ChangeRunstate(client SkytapClient, runstate string, desiredRunstates ...string) (*VirtualMachine, error) {
-	log.WithFields(log.Fields{"changeState": runstate, "targetState": desiredRunstates, "vmId": vm.Id}).Info("Changing VM runstate")
+	client.logger().Info("Changing VM runstate", "changeState", runstate, "targetState", desiredRunstates, "vmId", vm.Id)
 
 	ready, err := vm.WaitUntilReady(client)
 	if err != nil {
 		return ready, err
 	}
+	if err = CheckRunstateChange(Runstate(ready.Runstate), Runstate(runstate)); err != nil {
+		return ready, err
+	}
+	if ready.Runstate == string(Runstate(runstate).Result()) && runstate != RunStateReset {
+		return ready, nil
+	}
 	changeState := func(s *sling.Sling) *sling.Sling {
 		return s.Put(vmIdPath(vm.Id)).BodyJSON(&RunstateBody{Runstate: runstate})
 	}
@@ -214,6 +310,13 @@ func (vm *VirtualMachine) ChangeRunstate(client SkytapClient, runstate string, d
 	return vm.WaitUntilInState(client, desiredRunstates, true)
 }
 
+/*
+ ChangeRunstate, polling as opts say instead of the client's WaitOptions. If opts is nil the client's are used.
+*/
+func (vm *VirtualMachine) ChangeRunstateWithOptions(client SkytapClient, opts *WaitOptions, runstate string, desiredRunstates ...string) (*VirtualMachine, error) {
+	return vm.ChangeRunstate(client.withCallWaitOptions(opts), runstate, desiredRunstates...)
+}
+
 func (vm *VirtualMachine) GetCredentials(client SkytapClient) ([]VmCredential, error) {
 	credentialReq := func(s *sling.Sling) *sling.Sling {
 		return s.Get(vmCredentialPath(vm.Id))
@@ -249,7 +352,7 @@ func (vm *VirtualMachine) AddDisk(client SkytapClient, envId string, diskSize in
 		return s.Put(vmUpdatePath(vm.Id)).BodyJSON(hw)
 	}
 
-	log.WithFields(log.Fields{"vmId": vm.Id, "diskSize": diskSize}).Infof("Adding disk")
+	client.logger().Info("Adding disk", "vmId", vm.Id, "diskSize", diskSize)
 	_, err := RunSkytapRequest(client, false, vm, hardwareReq)
 
 	if err != nil {
@@ -263,6 +366,16 @@ func (vm *VirtualMachine) AddDisk(client SkytapClient, envId string, diskSize in
 
 }
 
+/*
+ Adds a disk to a VM in the background. See AddDisk; vm itself isn't updated.
+*/
+func (vm *VirtualMachine) AddDiskAsync(client SkytapClient, envId string, diskSize int, restartVm bool) *Operation[*VirtualMachine] {
+	target := *vm
+	return StartOperation(client, func(client SkytapClient) (*VirtualMachine, error) {
+		return target.AddDisk(client, envId, diskSize, restartVm)
+	})
+}
+
 /*
  Resize Disk with specified ID
 */
@@ -291,7 +404,7 @@ func (vm *VirtualMachine) ResizeDisk(client SkytapClient, envId string, diskId s
 		return s.Put(vmUpdatePath(vm.Id)).BodyJSON(hw)
 	}
 
-	log.WithFields(log.Fields{"vmId": vm.Id, "diskId": diskId, "diskSize": diskSize}).Infof("Resizing disk")
+	client.logger().Info("Resizing disk", "vmId", vm.Id, "diskId", diskId, "diskSize", diskSize)
 	_, err := RunSkytapRequest(client, false, vm, hardwareReq)
 
 	if err != nil {
@@ -309,7 +422,7 @@ func (vm *VirtualMachine) ResizeDisk(client SkytapClient, envId string, diskId s
  Add a network interface to VM
 */
 func (vm *VirtualMachine) AddNetworkInterface(client SkytapClient, envId, ip, host, nic_type string, restartVm bool) (*NetworkInterface, error) {
-	log.WithFields(log.Fields{"envId": envId, "vmId": vm.Id, "nic_type": nic_type, "ip": ip, "hostname": host}).Infof("Adding interface")
+	client.logger().Info("Adding interface", "envId", envId, "vmId", vm.Id, "nic_type", nic_type, "ip", ip, "hostname", host)
 	if vm.Runstate != RunStateStop {
 		_, err := vm.Stop(client)
 		if err != nil {
@@ -330,7 +443,7 @@ func (vm *VirtualMachine) AddNetworkInterface(client SkytapClient, envId, ip, ho
 	}
 
 	_, err := RunSkytapRequest(client, true, intr, addReq)
-	log.WithField("err", err).Info("Finished Add Interface Request")
+	client.logger().Info("Finished Add Interface Request", "err", err)
 	if err != nil {
 		return nil, err
 	}
@@ -347,15 +460,15 @@ func (vm *VirtualMachine) AddNetworkInterface(client SkytapClient, envId, ip, ho
  Update network interface on VM
 */
 func (vm *VirtualMachine) UpdateNetworkInterface(client SkytapClient, network_interface *NetworkInterface, envId, interfaceId string) error {
-	log.WithFields(log.Fields{"envId": envId, "vmId": vm.Id, "interfaceId": interfaceId}).Infof("Updating interface")
+	client.logger().Info("Updating interface", "envId", envId, "vmId", vm.Id, "interfaceId", interfaceId)
 
 	updateReq := func(s *sling.Sling) *sling.Sling {
 		path := fmt.Sprintf("%s/%s/%s/%s/%s/%s.json", EnvironmentPath, envId, VmPath, vm.Id, InterfacePath, interfaceId)
-		log.WithField("path", path).Info("")
+		client.logger().Debug("Updating interface", "path", path)
 		return s.Put(path).BodyJSON(network_interface)
 	}
 	_, err := RunSkytapRequest(client, true, network_interface, updateReq)
-	log.WithField("err", err).Info("Finished Update Interface Request")
+	client.logger().Info("Finished Update Interface Request", "err", err)
 
 	return err
 }
@@ -364,7 +477,7 @@ func (vm *VirtualMachine) UpdateNetworkInterface(client SkytapClient, network_in
  Remove network interface from VM
 */
 func (vm *VirtualMachine) RemoveNetworkInterface(client SkytapClient, envId, interfaceId string) error {
-	log.WithFields(log.Fields{"envId": envId, "vmId": vm.Id, "interfaceId": interfaceId}).Infof("Removing interface")
+	client.logger().Info("Removing interface", "envId", envId, "vmId", vm.Id, "interfaceId", interfaceId)
 	delReq := func(s *sling.Sling) *sling.Sling {
 		return s.Delete(networkInterfacePath(envId, vm.Id, interfaceId))
 	}
@@ -384,7 +497,7 @@ func (vm *VirtualMachine) RenameNetworkInterface(client SkytapClient, envId stri
 
 	interfaceResp := &NetworkInterface{}
 
-	log.WithFields(log.Fields{"newName": name, "interfaceId": interfaceId, "envId": envId, "vmId": vm.Id}).Infof("Renaming interface")
+	client.logger().Info("Renaming interface", "newName", name, "interfaceId", interfaceId, "envId", envId, "vmId", vm.Id)
 	_, err := RunSkytapRequest(client, false, interfaceResp, nameReq)
 	return interfaceResp, err
 }
@@ -403,7 +516,7 @@ func (vm *VirtualMachine) UpdateHardware(client SkytapClient, hardware Hardware,
 
 	newVm := &VirtualMachine{}
 
-	log.WithFields(log.Fields{"vmId": vm.Id}).Infof("Updating VM hardware: %+v", hardware)
+	client.logger().Info("Updating VM hardware", "vmId", vm.Id, "hardware", hardware)
 	_, err := RunSkytapRequest(client, false, newVm, hardwareReq)
 
 	if err != nil {
@@ -423,7 +536,7 @@ func (vm *VirtualMachine) ChangeAttribute(client SkytapClient, queryStruct inter
 
 	newVm := &VirtualMachine{}
 
-	log.WithFields(log.Fields{"vmId": vm.Id}).Infof("Updating VM attribute: %+v", queryStruct)
+	client.logger().Info("Updating VM attribute", "vmId", vm.Id, "attribute", queryStruct)
 	_, err := RunSkytapRequest(client, false, newVm, changeReq)
 
 	return newVm, err
@@ -504,11 +617,21 @@ func GetVirtualMachine(client SkytapClient, vmId string) (*VirtualMachine, error
 	return vm, err
 }
 
+/*
+ Calls fn with each VM in an environment, paged as given by opts.
+*/
+func ListEnvironmentVirtualMachines(client SkytapClient, envId string, opts *ListOptions, fn func(vm *VirtualMachine) error) error {
+	listVms := func(s *sling.Sling) *sling.Sling {
+		return s.Get(fmt.Sprintf("%s/%s/%s.json", EnvironmentPath, envId, VmPath))
+	}
+	return ListEach(client, true, opts, listVms, fn)
+}
+
 /*
  Delete a VM.
 */
 func DeleteVirtualMachine(client SkytapClient, vmId string) error {
-	log.WithFields(log.Fields{"vmId": vmId}).Info("Deleting VM")
+	client.logger().Info("Deleting VM", "vmId", vmId)
 
 	deleteVm := func(s *sling.Sling) *sling.Sling { return s.Delete(vmIdPath(vmId)) }
 	_, err := RunSkytapRequest(client, false, nil, deleteVm)
@@ -12,7 +12,7 @@ import (
 
 func TestDeleteVirtualMachine(t *testing.T) {
 	client := skytapClient(t)
-	server := getMockServer(client)
+	server := getMockServer(&client)
 	defer server.Close()
 
 	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -30,7 +30,7 @@ func TestVmCredentials(t *testing.T) {
 	credJson := readJson(t, "testdata/credentials.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, vmJson)
+	server := getMockServerForString(&client, vmJson)
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -56,7 +56,7 @@ func TestVmWaitUntilReady(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, strings.Replace(vmJson, "stopped", "busy", 1))
+	server := getMockServerForString(&client, strings.Replace(vmJson, "stopped", "busy", 1))
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -77,7 +77,7 @@ func TestVmStart(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, vmJson)
+	server := getMockServerForString(&client, vmJson)
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -103,7 +103,7 @@ func TestVmSuspend(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, strings.Replace(vmJson, "stopped", "running", 1))
+	server := getMockServerForString(&client, strings.Replace(vmJson, "stopped", "running", 1))
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -129,7 +129,7 @@ func TestVmKill(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, strings.Replace(vmJson, "stopped", "running", 1))
+	server := getMockServerForString(&client, strings.Replace(vmJson, "stopped", "running", 1))
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -150,12 +150,42 @@ func TestVmKill(t *testing.T) {
 	require.Equal(t, RunStateStop, killed.Runstate, "Should be stopped/killed")
 }
 
+func TestVmReset(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+	runningJson := strings.Replace(vmJson, "stopped", "running", 1)
+
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var requests []string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/vms/1001", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+strings.TrimSpace(string(body)))
+		fmt.Fprintln(w, runningJson)
+	})
+
+	vm := &VirtualMachine{Id: "1001", Runstate: RunStateStart}
+	reset, err := vm.Reset(client)
+	require.NoError(t, err)
+	require.Equal(t, RunStateStart, reset.Runstate)
+	require.Equal(t, []string{"GET ", `PUT {"runstate":"reset"}`, "GET "}, requests)
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method, "A stopped VM should not be reset")
+		fmt.Fprintln(w, vmJson)
+	})
+	_, err = vm.Reset(client)
+	require.EqualError(t, err, "Unable to change runstate from stopped to reset")
+}
+
 func TestChangeNetworkHostname(t *testing.T) {
 	envJson := readJson(t, "testdata/environment-1.json")
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, envJson)
+	server := getMockServerForString(&client, envJson)
 	defer server.Close()
 
 	env, err := GetEnvironment(client, "1")
@@ -178,7 +208,7 @@ func TestUpdateHardware(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, vmJson)
+	server := getMockServerForString(&client, vmJson)
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -232,7 +262,7 @@ func TestChangeName(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, vmJson)
+	server := getMockServerForString(&client, vmJson)
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -253,7 +283,7 @@ func TestAddNetworkInterface(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, vmJson)
+	server := getMockServerForString(&client, vmJson)
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -275,7 +305,7 @@ func TestDeleteNetworkInterface(t *testing.T) {
 	vmJson := readJson(t, "testdata/vm-1001.json")
 
 	client := skytapClient(t)
-	server := getMockServerForString(client, vmJson)
+	server := getMockServerForString(&client, vmJson)
 	defer server.Close()
 
 	vm, err := GetVirtualMachine(client, "1001")
@@ -0,0 +1,152 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+ Controls how a resource is polled while waiting for it to change state, such as in WaitUntilInState, the
+ WaitUntilReady methods and the ChangeRunstate methods.
+
+ Waits use the client's WaitOptions, set with WithWaitOptions when creating the client. The WithOptions variants of
+ those calls, such as WaitUntilInStateWithOptions, take options for a single call instead.
+
+ A wait ends early if the client's context is done, see SkytapClient.WithContext.
+*/
+type WaitOptions struct {
+	// Delay before polling again after the first check, if zero the default's is used.
+	InitialInterval time.Duration
+	// Factor the delay grows by after each poll, values below 1 keep it constant.
+	Multiplier float64
+	// Upper bound on the delay between polls, zero for no bound.
+	MaxInterval time.Duration
+	// How long to wait in total before giving up, zero waits until the client's context is done.
+	Timeout time.Duration
+}
+
+/*
+ Create the wait options used by clients that don't configure any.
+
+ Polling starts quickly so that small VMs aren't waited on for long, and backs off so that environments which take
+ many minutes to change state are polled sparingly.
+*/
+func NewDefaultWaitOptions() *WaitOptions {
+	return &WaitOptions{
+		InitialInterval: 2 * time.Second,
+		Multiplier:      1.5,
+		MaxInterval:     30 * time.Second,
+		Timeout:         200 * time.Second,
+	}
+}
+
+/*
+ Error returned when a wait gives up because its timeout passed before the resource was ready. The last representation
+ fetched is returned along with it.
+*/
+type WaitTimeoutError struct {
+	// How long was waited before giving up.
+	Waited  time.Duration
+	message string
+}
+
+func (e *WaitTimeoutError) Error() string { return e.message }
+
+/*
+ True if err is a *WaitTimeoutError.
+*/
+func IsWaitTimeout(err error) bool {
+	var timeoutErr *WaitTimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+var errWaitTimeout = errors.New("wait timed out")
+
+/*
+ Returns a copy of the client whose waits use the given options.
+*/
+func (client SkytapClient) WithWaitOptions(opts *WaitOptions) SkytapClient {
+	client.WaitOptions = opts
+	return client
+}
+
+func (client SkytapClient) waitOptions() *WaitOptions {
+	if client.WaitOptions != nil {
+		return client.WaitOptions
+	}
+	return NewDefaultWaitOptions()
+}
+
+/*
+ The client to make a single call with, which waits as opts say if they're set.
+*/
+func (client SkytapClient) withCallWaitOptions(opts *WaitOptions) SkytapClient {
+	if opts == nil {
+		return client
+	}
+	return client.WithWaitOptions(opts)
+}
+
+func (opts *WaitOptions) nextInterval(interval time.Duration) time.Duration {
+	if opts.Multiplier > 1 {
+		interval = time.Duration(float64(interval) * opts.Multiplier)
+	}
+	if opts.MaxInterval > 0 && interval > opts.MaxInterval {
+		interval = opts.MaxInterval
+	}
+	return interval
+}
+
+/*
+ Calls check until it reports done or fails, sleeping between calls as the client's WaitOptions say. check is given a
+ client bound to the wait's context, which it should make its requests with.
+
+ Returns errWaitTimeout if the options' timeout passes first, or the client context's error if it is done first.
+*/
+func (client SkytapClient) poll(check func(client SkytapClient) (bool, error)) error {
+	opts := client.waitOptions()
+	parent := client.Context()
+	ctx := parent
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, opts.Timeout)
+		defer cancel()
+	}
+	client = client.WithContext(ctx)
+
+	interval := opts.InitialInterval
+	if interval <= 0 {
+		interval = NewDefaultWaitOptions().InitialInterval
+	}
+	for {
+		done, err := check(client)
+		if err == nil && !done {
+			err = sleepWithContext(ctx, interval)
+			interval = opts.nextInterval(interval)
+		}
+		if err != nil && parent.Err() != nil {
+			return parent.Err()
+		}
+		if err != nil && ctx.Err() != nil {
+			return errWaitTimeout
+		}
+		if done || err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+/*
+ Serves a VM that stays busy for the given number of reads, recording when each read arrives.
+*/
+type busyVmHandler struct {
+	sync.Mutex
+	vmJson string
+	busy   int
+	reads  []time.Time
+}
+
+func (h *busyVmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.reads = append(h.reads, time.Now())
+	if h.busy < 0 || len(h.reads) <= h.busy {
+		fmt.Fprintln(w, strings.Replace(h.vmJson, "stopped", "busy", 1))
+	} else {
+		fmt.Fprintln(w, h.vmJson)
+	}
+}
+
+func TestWaitBackoff(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	handler := &busyVmHandler{vmJson: readJson(t, "testdata/vm-1001.json"), busy: 5}
+	server.Config.Handler = handler
+	client = client.WithWaitOptions(&WaitOptions{InitialInterval: 5 * time.Millisecond, Multiplier: 2, MaxInterval: 20 * time.Millisecond})
+
+	vm, err := (&VirtualMachine{Id: "1001"}).WaitUntilReady(client)
+	require.NoError(t, err)
+	require.Equal(t, RunStateStop, vm.Runstate)
+	require.Equal(t, 6, len(handler.reads))
+
+	// Intervals of 5, 10, 20 then 20ms.
+	require.True(t, handler.reads[2].Sub(handler.reads[1]) >= 10*time.Millisecond)
+	require.True(t, handler.reads[5].Sub(handler.reads[4]) >= 20*time.Millisecond)
+}
+
+func TestNextInterval(t *testing.T) {
+	opts := &WaitOptions{Multiplier: 1.5, MaxInterval: 30 * time.Second}
+	require.Equal(t, 3*time.Second, opts.nextInterval(2*time.Second))
+	require.Equal(t, 30*time.Second, opts.nextInterval(25*time.Second))
+
+	opts = &WaitOptions{Multiplier: 0.5}
+	require.Equal(t, time.Second, opts.nextInterval(time.Second), "Intervals should never shrink")
+}
+
+func TestWaitTimeout(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	vmHandler := &busyVmHandler{vmJson: readJson(t, "testdata/vm-1001.json"), busy: -1}
+	templateJson := strings.Replace(readJson(t, "testdata/template-2.json"), `"busy": null`, `"busy": true`, 1)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/vms/") {
+			vmHandler.ServeHTTP(w, r)
+		} else {
+			fmt.Fprintln(w, templateJson)
+		}
+	})
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond, Timeout: 20 * time.Millisecond}
+
+	vm, err := (&VirtualMachine{Id: "1001"}).WaitUntilReady(client)
+	require.True(t, IsWaitTimeout(err), "Should be a *WaitTimeoutError, got %v", err)
+	require.Contains(t, err.Error(), "Didn't achieve any desired runstate")
+	require.Equal(t, RunStateBusy, vm.Runstate, "Should return the last representation read")
+
+	_, err = (&Template{Id: "2"}).WaitUntilReady(client)
+	require.EqualError(t, err, "Template 2 still busy after 0 seconds")
+	require.True(t, err.(*WaitTimeoutError).Waited >= 20*time.Millisecond)
+}
+
+func TestWaitWithOptions(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	vmHandler := &busyVmHandler{vmJson: readJson(t, "testdata/vm-1001.json"), busy: -1}
+	templateJson := strings.Replace(readJson(t, "testdata/template-2.json"), `"busy": null`, `"busy": true`, 1)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/vms/") {
+			vmHandler.ServeHTTP(w, r)
+		} else {
+			fmt.Fprintln(w, templateJson)
+		}
+	})
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond, Timeout: time.Minute}
+	opts := &WaitOptions{InitialInterval: time.Millisecond, Timeout: 20 * time.Millisecond}
+
+	start := time.Now()
+	_, err := WaitUntilInStateWithOptions(client, opts, []string{RunStateStop}, &VirtualMachine{Id: "1001"}, false)
+	require.True(t, IsWaitTimeout(err), "The call's timeout should be used")
+	_, err = (&VirtualMachine{Id: "1001"}).WaitUntilReadyWithOptions(client, opts)
+	require.True(t, IsWaitTimeout(err))
+	_, err = (&VirtualMachine{Id: "1001"}).ChangeRunstateWithOptions(client, opts, RunStateStart, RunStateStart)
+	require.True(t, IsWaitTimeout(err))
+	_, err = (&Template{Id: "2"}).WaitUntilReadyWithOptions(client, opts)
+	require.True(t, IsWaitTimeout(err))
+	require.True(t, time.Since(start) < 10*time.Second, "The client's timeout should not be used")
+}
+
+func TestDefaultWaitTimeout(t *testing.T) {
+	require.Equal(t, 200*time.Second, NewDefaultWaitOptions().Timeout)
+}
+
+func TestWaitContext(t *testing.T) {
+	client := skytapClient(t)
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = &busyVmHandler{vmJson: readJson(t, "testdata/vm-1001.json"), busy: -1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond, Timeout: time.Minute}
+
+	_, err := (&VirtualMachine{Id: "1001"}).WaitUntilReady(client.WithContext(ctx))
+	require.Equal(t, context.DeadlineExceeded, err, "The caller's context ending the wait should be reported as such")
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"time"
+)
+
+/*
+ A change of a watched resource's runstate.
+*/
+type RunstateEvent struct {
+	// Runstate before and after the change.
+	From string
+	To   string
+	// When the change was seen.
+	Time time.Time
+	// Representation of the resource fetched when the change was seen.
+	Resource RunstateAwareResource
+	// Set on the last event if watching stopped because the resource couldn't be fetched, in which case the other
+	// fields are empty.
+	Err error
+}
+
+/*
+ Watches a resource, sending an event on the returned channel each time its runstate is seen to change from the
+ previous one, starting from r's.
+
+ The resource is polled at the intervals set by the client's WaitOptions, backing off while it stays in the same
+ runstate and polling quickly again after each change; the options' timeout is not used. The channel is
+ closed once ctx is done, or after an event with Err set if fetching the resource fails.
+*/
+func Watch(ctx context.Context, client SkytapClient, r RunstateAwareResource) <-chan RunstateEvent {
+	events := make(chan RunstateEvent)
+	client = client.WithContext(ctx)
+	opts := client.waitOptions()
+	initialInterval := opts.InitialInterval
+	if initialInterval <= 0 {
+		initialInterval = NewDefaultWaitOptions().InitialInterval
+	}
+
+	go func() {
+		defer close(events)
+
+		runstate := r.RunstateStr()
+		interval := initialInterval
+		for {
+			current, err := r.Refresh(client)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				sendEvent(ctx, events, RunstateEvent{Err: err})
+				return
+			}
+
+			if current.RunstateStr() != runstate {
+				event := RunstateEvent{From: runstate, To: current.RunstateStr(), Time: time.Now(), Resource: current}
+				if !sendEvent(ctx, events, event) {
+					return
+				}
+				runstate = current.RunstateStr()
+				interval = initialInterval
+			}
+
+			if sleepWithContext(ctx, interval) != nil {
+				return
+			}
+			interval = opts.nextInterval(interval)
+		}
+	}()
+	return events
+}
+
+func sendEvent(ctx context.Context, events chan<- RunstateEvent, event RunstateEvent) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
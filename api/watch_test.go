@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	vmJson := readJson(t, "testdata/vm-1001.json")
+	runstates := []string{RunStateStop, RunStateBusy, RunStateBusy, RunStateStart}
+
+	client := skytapClient(t)
+	client.WaitOptions = &WaitOptions{InitialInterval: time.Millisecond}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	var mu sync.Mutex
+	reads := 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		runstate := runstates[len(runstates)-1]
+		if reads < len(runstates) {
+			runstate = runstates[reads]
+		}
+		reads++
+		fmt.Fprintln(w, strings.Replace(vmJson, `"runstate": "stopped"`, `"runstate": "`+runstate+`"`, 1))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := Watch(ctx, client, &VirtualMachine{Id: "1001", Runstate: RunStateStop})
+
+	first := <-events
+	require.NoError(t, first.Err)
+	require.Equal(t, RunStateStop, first.From)
+	require.Equal(t, RunStateBusy, first.To)
+	require.False(t, first.Time.IsZero())
+
+	second := <-events
+	require.Equal(t, RunStateBusy, second.From)
+	require.Equal(t, RunStateStart, second.To)
+	require.Equal(t, "1001", second.Resource.(*VirtualMachine).Id)
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatchError(t *testing.T) {
+	client := skytapClient(t)
+	client.RetryPolicy = NoRetryPolicy{}
+	server := getMockServer(&client)
+	defer server.Close()
+
+	server.Config.Handler = &scriptedHandler{statuses: []int{404}}
+
+	events := Watch(context.Background(), client, &Environment{Id: "1"})
+	event := <-events
+	require.True(t, IsNotFound(event.Err))
+	_, open := <-events
+	require.False(t, open, "The channel should be closed after an error")
+}
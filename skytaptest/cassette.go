@@ -0,0 +1,567 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skytaptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Set to any non-empty value to have ModeFromEnv select ModeRecord.
+	EnvRecord = "SKYTAP_RECORD"
+
+	ScrubbedUsername = "skytap-user"
+	ScrubbedApiKey   = "REDACTED"
+)
+
+/*
+ Whether a Recorder captures real traffic or plays back a cassette.
+*/
+type Mode int
+
+const (
+	// Serve responses from the cassette file, no requests leave the process.
+	ModeReplay Mode = iota
+	// Send requests to the real API and save them to the cassette file on Stop.
+	ModeRecord
+)
+
+/*
+ ModeRecord if SKYTAP_RECORD is set, otherwise ModeReplay.
+*/
+func ModeFromEnv() Mode {
+	if os.Getenv(EnvRecord) != "" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+/*
+ Recorded request/response pairs, as saved in a cassette file.
+*/
+type Cassette struct {
+	// Named values registered with Recorder.Id, scrubbed like the interactions.
+	Values       map[string]string `json:"values,omitempty"`
+	Interactions []Interaction     `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	Url    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+/*
+ Transport that records API traffic to a cassette file, or replays it from one. Install it on a client with
+ api.WithTransport, or as the Transport of SkytapClient.HttpClient.
+
+ Recorded cassettes are scrubbed when saved: Authorization and cookie headers are dropped, the API credentials are
+ replaced with ScrubbedUsername and ScrubbedApiKey, resource ids are renumbered in request URLs, Location headers and
+ JSON id and URL fields, and public IPv4 addresses are mapped into the documentation ranges. Each real value is always
+ replaced by the same fake one, so ids returned by one response match the ids sent in later requests. Private and
+ loopback addresses are left alone.
+
+ Requests are replayed in recorded order, matching on method, path, query and body. The scheme and host are ignored,
+ so replay works with any base URL.
+*/
+type Recorder struct {
+	// Cassette file to read from or save to.
+	Filename string
+	Mode     Mode
+	// When replaying, fail requests with no unused recorded match instead of reusing the last match or answering 404.
+	Strict bool
+	// Transport used to reach the real API when recording, if nil http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	mu          sync.Mutex
+	cassette    Cassette
+	used        []bool
+	credentials []string
+	ids         []string
+}
+
+/*
+ Create a recorder for the given cassette file. In replay mode the file is loaded, and must exist.
+*/
+func NewRecorder(filename string, mode Mode) (*Recorder, error) {
+	r := &Recorder{Filename: filename, Mode: mode, cassette: Cassette{Values: map[string]string{}}}
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &r.cassette); err != nil {
+		return nil, fmt.Errorf("parsing cassette %s: %s", filename, err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+/*
+ An HTTP client using this recorder as its transport.
+*/
+func (r *Recorder) HttpClient() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+/*
+ Returns an id the test supplies itself, such as a template id from the environment, so it can be replayed.
+
+ When recording, realId is registered for scrubbing, saved under name and returned unchanged. When replaying the
+ scrubbed id saved under name is returned, and realId is ignored.
+*/
+func (r *Recorder) Id(name string, realId string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Mode == ModeRecord {
+		r.cassette.Values[name] = realId
+		r.ids = append(r.ids, realId)
+		return realId
+	}
+	return r.cassette.Values[name]
+}
+
+/*
+ Number of recorded interactions not yet replayed.
+*/
+func (r *Recorder) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := 0
+	for _, used := range r.used {
+		if !used {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+/*
+ Finish recording and save the scrubbed cassette. Does nothing when replaying.
+*/
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Mode != ModeRecord {
+		return nil
+	}
+	cassette := newScrubber(r.credentials, r.ids).scrubCassette(r.cassette)
+	b, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.Filename, b, 0644)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	if r.Mode == ModeRecord {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	outgoing := req.Clone(req.Context())
+	outgoing.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, err := transport.RoundTrip(outgoing)
+	if err != nil {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if username, apiKey, ok := req.BasicAuth(); ok {
+		r.credentials = append(r.credentials, username, apiKey)
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  RecordedRequest{Method: req.Method, Url: req.URL.String(), Body: string(body)},
+		Response: RecordedResponse{Status: resp.StatusCode, Headers: resp.Header.Clone(), Body: string(respBody)},
+	})
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !matchesRequest(interaction.Request, req, body) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return replayResponse(req, interaction.Response), nil
+		}
+		last = i
+	}
+
+	if r.Strict {
+		return nil, fmt.Errorf("no unused interaction in cassette %s matches %s %s", r.Filename, req.Method, req.URL.RequestURI())
+	}
+	if last >= 0 {
+		return replayResponse(req, r.cassette.Interactions[last].Response), nil
+	}
+	return replayResponse(req, RecordedResponse{
+		Status: http.StatusNotFound,
+		Body:   fmt.Sprintf(`{"error": "%s %s not recorded in cassette"}`, req.Method, req.URL.Path),
+	}), nil
+}
+
+func matchesRequest(recorded RecordedRequest, req *http.Request, body []byte) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+	recordedUrl, err := req.URL.Parse(recorded.Url)
+	if err != nil || recordedUrl.Path != req.URL.Path || recordedUrl.Query().Encode() != req.URL.Query().Encode() {
+		return false
+	}
+	return equalBodies([]byte(recorded.Body), body)
+}
+
+/*
+ Compares bodies as JSON when both parse, so key order and whitespace don't matter.
+*/
+func equalBodies(a []byte, b []byte) bool {
+	a, b = bytes.TrimSpace(a), bytes.TrimSpace(b)
+	var aJson, bJson interface{}
+	if json.Unmarshal(a, &aJson) == nil && json.Unmarshal(b, &bJson) == nil {
+		return reflect.DeepEqual(aJson, bJson)
+	}
+	return bytes.Equal(a, b)
+}
+
+func replayResponse(req *http.Request, recorded RecordedResponse) *http.Response {
+	header := recorded.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}
+}
+
+var (
+	scrubbedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Content-Length"}
+	// Matches an IPv4 address, or a word such as an id, which may be hyphenated like "nic-1234-5678-0".
+	scrubTokenPattern = regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}|[A-Za-z0-9-]+`)
+	idPartPattern     = regexp.MustCompile(`[A-Za-z0-9]+`)
+	digitsPattern     = regexp.MustCompile(`\d+`)
+	idPrefixPattern   = regexp.MustCompile(`^[A-Za-z]+-`)
+	// Replacement addresses, from the ranges reserved for documentation.
+	scrubbedNets = []string{"192.0.2.", "198.51.100.", "203.0.113."}
+)
+
+/*
+ Consistently replaces credentials, ids and public IPs in a cassette.
+*/
+type scrubber struct {
+	credentials map[string]string
+	ids         map[string]string
+	ips         map[string]string
+}
+
+func newScrubber(credentials []string, ids []string) *scrubber {
+	s := &scrubber{credentials: map[string]string{}, ids: map[string]string{}, ips: map[string]string{}}
+	for i := 0; i+1 < len(credentials); i += 2 {
+		s.credentials[credentials[i]] = ScrubbedUsername
+		s.credentials[credentials[i+1]] = ScrubbedApiKey
+	}
+	for _, id := range ids {
+		s.addId(id)
+	}
+	return s
+}
+
+func (s *scrubber) scrubCassette(cassette Cassette) Cassette {
+	for _, interaction := range cassette.Interactions {
+		s.collectIds(interaction.Request.Url, interaction.Request.Body, interaction.Response.Body)
+	}
+
+	result := Cassette{Values: map[string]string{}}
+	names := make([]string, 0, len(cassette.Values))
+	for name := range cassette.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.Values[name] = s.scrubIds(s.scrubText(cassette.Values[name]))
+	}
+
+	for _, interaction := range cassette.Interactions {
+		header := http.Header{}
+		for k, v := range interaction.Response.Headers {
+			header[k] = v
+		}
+		for _, name := range scrubbedHeaders {
+			header.Del(name)
+		}
+		for name, values := range header {
+			for i := range values {
+				values[i] = s.scrubText(values[i])
+				if name == "Location" || name == "Content-Location" {
+					values[i] = s.scrubIds(values[i])
+				}
+			}
+		}
+		if len(header) == 0 {
+			header = nil
+		}
+
+		result.Interactions = append(result.Interactions, Interaction{
+			Request: RecordedRequest{
+				Method: interaction.Request.Method,
+				Url:    s.scrubIds(s.scrubText(interaction.Request.Url)),
+				Body:   s.scrubBody(interaction.Request.Body),
+			},
+			Response: RecordedResponse{
+				Status:  interaction.Response.Status,
+				Headers: header,
+				Body:    s.scrubBody(interaction.Response.Body),
+			},
+		})
+	}
+	return result
+}
+
+/*
+ Registers ids found in URL path segments, and in JSON "id", "*_id" and "*_ids" fields, in order of appearance.
+*/
+func (s *scrubber) collectIds(requestUrl string, bodies ...string) {
+	s.collectPathIds(requestUrl)
+	for _, body := range bodies {
+		decoder := json.NewDecoder(strings.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if decoder.Decode(&value) == nil {
+			s.collectJsonIds("", value)
+		}
+	}
+}
+
+func (s *scrubber) collectPathIds(value string) {
+	if i := strings.Index(value, "://"); i >= 0 {
+		value = value[i+3:]
+		if j := strings.Index(value, "/"); j >= 0 {
+			value = value[j:]
+		} else {
+			return
+		}
+	}
+	if i := strings.IndexAny(value, "?#"); i >= 0 {
+		value = value[:i]
+	}
+	for _, segment := range strings.Split(value, "/") {
+		segment = strings.TrimSuffix(segment, ".json")
+		if segment != "v1" && segment != "v2" && digitsPattern.MatchString(segment) {
+			s.addId(segment)
+		}
+	}
+}
+
+func (s *scrubber) collectJsonIds(key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.collectJsonIds(k, v[k])
+		}
+	case []interface{}:
+		for _, item := range v {
+			s.collectJsonIds(key, item)
+		}
+	case json.Number:
+		if isIdKey(key) {
+			s.addId(v.String())
+		}
+	case string:
+		if isIdKey(key) {
+			s.addId(v)
+		} else if isUrlKey(key) {
+			s.collectPathIds(v)
+		}
+	}
+}
+
+func isIdKey(key string) bool {
+	return key == "id" || strings.HasSuffix(key, "_id") || strings.HasSuffix(key, "_ids")
+}
+
+func isUrlKey(key string) bool {
+	return key == "url" || strings.HasSuffix(key, "_url")
+}
+
+func (s *scrubber) addId(id string) {
+	if id == "" || !digitsPattern.MatchString(id) || s.ids[id] != "" {
+		return
+	}
+	s.ids[id] = idPrefixPattern.FindString(id) + fmt.Sprint(1000001+len(s.ids))
+}
+
+/*
+ Scrubs a body, walking it as JSON if possible so that only strings are touched, and ids only in id and URL fields.
+*/
+func (s *scrubber) scrubBody(body string) string {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if body == "" || decoder.Decode(&value) != nil {
+		return s.scrubText(body)
+	}
+	b, err := json.Marshal(s.scrubJson("", value))
+	if err != nil {
+		return s.scrubText(body)
+	}
+	return string(b)
+}
+
+func (s *scrubber) scrubJson(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[s.scrubText(k)] = s.scrubJson(k, item)
+		}
+		return result
+	case []interface{}:
+		for i := range v {
+			v[i] = s.scrubJson(key, v[i])
+		}
+		return v
+	case json.Number:
+		if id, ok := s.ids[v.String()]; ok && isIdKey(key) && !idPrefixPattern.MatchString(id) {
+			return json.Number(id)
+		}
+		return v
+	case string:
+		if isIdKey(key) || isUrlKey(key) {
+			return s.scrubIds(s.scrubText(v))
+		}
+		return s.scrubText(v)
+	default:
+		return v
+	}
+}
+
+/*
+ Replaces credentials, then public IPs. Ids are left to scrubIds.
+*/
+func (s *scrubber) scrubText(text string) string {
+	for credential, replacement := range s.credentials {
+		if credential != "" {
+			text = strings.ReplaceAll(text, credential, replacement)
+		}
+	}
+	return scrubTokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		if ip := net.ParseIP(token); ip != nil {
+			return s.scrubIp(ip, token)
+		}
+		return token
+	})
+}
+
+/*
+ Replaces known ids in an id or URL. Only whole ids are replaced: a hyphenated id, or one part of it, such as the VM
+ id inside an interface id. Ids that merely appear inside a longer number or word, or an address, are left alone.
+*/
+func (s *scrubber) scrubIds(text string) string {
+	return scrubTokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		if net.ParseIP(token) != nil {
+			return token
+		}
+		if id, ok := s.ids[token]; ok {
+			return id
+		}
+		return idPartPattern.ReplaceAllStringFunc(token, func(part string) string {
+			if id, ok := s.ids[part]; ok {
+				return id
+			}
+			return part
+		})
+	})
+}
+
+func (s *scrubber) scrubIp(ip net.IP, token string) string {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || strings.HasPrefix(token, "255.") {
+		return token
+	}
+	if scrubbed, ok := s.ips[token]; ok {
+		return scrubbed
+	}
+	n := len(s.ips)
+	scrubbed := fmt.Sprintf("%s%d", scrubbedNets[(n/254)%len(scrubbedNets)], n%254+1)
+	s.ips[token] = scrubbed
+	return scrubbed
+}
@@ -0,0 +1,134 @@
+package skytaptest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/skytap/skytap-sdk-go/api"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+ Flow run once while recording and once while replaying.
+*/
+func cassetteFlow(t *testing.T, client api.SkytapClient, templateId string) (*api.Environment, *api.NetworkInterface) {
+	env, err := api.CreateNewEnvironment(client, templateId)
+	require.NoError(t, err)
+	env, err = env.Start(client)
+	require.NoError(t, err)
+
+	vm, err := api.GetVirtualMachine(client, env.Vms[0].Id)
+	require.NoError(t, err)
+	vm, err = vm.Stop(client)
+	require.NoError(t, err)
+	nic, err := vm.AddNetworkInterface(client, env.Id, "10.0.0.5", "web", "vmxnet3", false)
+	require.NoError(t, err)
+	nic, err = nic.AddPublishedService(client, 8080, env.Id, vm.Id)
+	require.NoError(t, err)
+	return env, nic
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cassette.json")
+
+	server := NewServer()
+	defer server.Close()
+	template := server.AddTemplate("Golden image", "US-West", "web")
+
+	recorder, err := NewRecorder(filename, ModeRecord)
+	require.NoError(t, err)
+	client := *server.Client(api.WithCredentials("me@example.com", "secret-api-key"), api.WithTransport(recorder))
+	recordedEnv, recordedNic := cassetteFlow(t, client, recorder.Id("template", template.Id))
+	require.Equal(t, "203.0.113.10", recordedNic.PublishedServices[0].ExternalIp)
+	require.NoError(t, recorder.Stop())
+
+	b, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	cassette := string(b)
+	require.NotContains(t, cassette, "secret-api-key")
+	require.NotContains(t, cassette, "me@example.com")
+	require.NotContains(t, cassette, "Authorization")
+	require.NotContains(t, cassette, `"`+recordedEnv.Id+`"`, "Ids should be scrubbed")
+	require.NotContains(t, cassette, "203.0.113.10", "Public IPs should be scrubbed")
+	require.Contains(t, cassette, "10.0.0.5", "Private IPs should be kept")
+
+	replayer, err := NewRecorder(filename, ModeReplay)
+	require.NoError(t, err)
+	replayer.Strict = true
+	client = *api.NewClient(
+		api.WithCredentials("someone-else", "other-key"),
+		api.WithBaseUrl("http://replay.invalid"),
+		api.WithTransport(replayer),
+		api.WithRetryPolicy(api.NoRetryPolicy{}))
+
+	env, nic := cassetteFlow(t, client, replayer.Id("template", "ignored"))
+	require.Equal(t, 0, replayer.Remaining(), "Every interaction should be replayed")
+	require.NotEqual(t, recordedEnv.Id, env.Id)
+	require.Equal(t, recordedEnv.Name, env.Name)
+	require.Equal(t, "192.0.2.1", nic.PublishedServices[0].ExternalIp)
+
+	_, err = api.GetEnvironment(client, env.Id)
+	require.Error(t, err, "Strict mode should fail once the cassette is used up")
+}
+
+func TestReplayNotStrict(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cassette.json")
+	cassette := `{"interactions": [
+		{"request": {"method": "GET", "url": "https://cloud.skytap.com/vms/1000001"},
+		 "response": {"status": 200, "body": "{\"id\": \"1000001\", \"runstate\": \"busy\"}"}},
+		{"request": {"method": "GET", "url": "https://cloud.skytap.com/vms/1000001"},
+		 "response": {"status": 200, "body": "{\"id\": \"1000001\", \"runstate\": \"running\"}"}}
+	]}`
+	require.NoError(t, ioutil.WriteFile(filename, []byte(cassette), 0644))
+
+	replayer, err := NewRecorder(filename, ModeReplay)
+	require.NoError(t, err)
+	client := *api.NewClient(api.WithTransport(replayer), api.WithRetryPolicy(api.NoRetryPolicy{}))
+
+	for _, runstate := range []string{api.RunStateBusy, api.RunStateStart, api.RunStateStart} {
+		vm, err := api.GetVirtualMachine(client, "1000001")
+		require.NoError(t, err)
+		require.Equal(t, runstate, vm.Runstate, "Should replay in order, then repeat the last match")
+	}
+
+	_, err = api.GetVirtualMachine(client, "2")
+	require.True(t, api.IsNotFound(err))
+}
+
+func TestScrubText(t *testing.T) {
+	s := newScrubber([]string{"me@example.com", "secret"}, []string{"661108"})
+	s.collectIds("https://cloud.skytap.com/v2/configurations/1234/vms/5678.json?keep=1", `{"vpn_id": "vpn-661108", "name": "web"}`)
+
+	require.Equal(t, "https://cloud.skytap.com/v2/configurations/1000002/vms/1000003.json?keep=1",
+		s.scrubIds("https://cloud.skytap.com/v2/configurations/1234/vms/5678.json?keep=1"))
+	require.Equal(t, "vpn-1000004 1000001", s.scrubIds("vpn-661108 661108"))
+	require.Equal(t, "nic-1000002-1000003-0", s.scrubIds("nic-1234-5678-0"), "Ids within an interface id should be scrubbed")
+	require.Equal(t, "12345 v5678b 10.12.34.5", s.scrubIds("12345 v5678b 10.12.34.5"), "Only whole ids should be scrubbed")
+	require.Equal(t, "skytap-user REDACTED", s.scrubText("me@example.com secret"))
+	require.Equal(t, "192.0.2.1 192.0.2.2/24 192.0.2.1 10.1.1.1", s.scrubText("52.1.2.3 52.1.2.0/24 52.1.2.3 10.1.1.1"))
+	require.Equal(t, "web 1234", s.scrubText("web 1234"), "Ids should only be scrubbed by scrubIds")
+}
+
+func TestScrubBodyOnlyScrubsIdFields(t *testing.T) {
+	s := newScrubber(nil, nil)
+	s.collectIds("https://cloud.skytap.com/v2/configurations/14.json", `{"id": "14", "vms": [{"id": 4, "name": "Ubuntu 14.04"}]}`)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(s.scrubBody(
+		`{"id": "14", "url": "https://cloud.skytap.com/v2/configurations/14", "vms": [{"id": 4, "name": "Ubuntu 14.04"}]}`)), &body))
+
+	require.Equal(t, "1000001", body["id"])
+	require.Equal(t, "https://cloud.skytap.com/v2/configurations/1000001", body["url"])
+	vm := body["vms"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, float64(1000002), vm["id"], "Numeric ids should be scrubbed")
+	require.Equal(t, "Ubuntu 14.04", vm["name"], "Ids should not be scrubbed from other fields")
+}
+
+func TestModeFromEnv(t *testing.T) {
+	t.Setenv(EnvRecord, "")
+	require.Equal(t, ModeReplay, ModeFromEnv())
+	t.Setenv(EnvRecord, "1")
+	require.Equal(t, ModeRecord, ModeFromEnv())
+}
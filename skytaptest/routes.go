@@ -0,0 +1,946 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skytaptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skytap/skytap-sdk-go/api"
+)
+
+/*
+ Dispatches a request by its path segments, with any /v2 prefix and .json suffix removed.
+*/
+func (s *Server) route(w http.ResponseWriter, r *http.Request, p []string) {
+	switch {
+	case match(p, api.EnvironmentPath):
+		s.environmentsHandler(w, r)
+	case match(p, api.EnvironmentPath, "*"):
+		s.environmentHandler(w, r, p[1])
+	case match(p, api.EnvironmentPath, "*", api.VmPath):
+		s.environmentVmsHandler(w, r, p[1])
+	case match(p, api.EnvironmentPath, "*", api.VmPath, "*"):
+		s.environmentVmHandler(w, r, p[1], p[3])
+	case match(p, api.EnvironmentPath, "*", api.NetworkPath):
+		s.networksHandler(w, r, p[1])
+	case match(p, api.EnvironmentPath, "*", api.NetworkPath, "*"):
+		s.networkHandler(w, r, p[1], p[3])
+	case match(p, api.EnvironmentPath, "*", api.NetworkPath, "*", api.VpnPath):
+		s.networkVpnsHandler(w, r, p[1], p[3])
+	case match(p, api.EnvironmentPath, "*", api.NetworkPath, "*", api.VpnPath, "*"):
+		s.networkVpnHandler(w, r, p[1], p[3], p[5])
+	case match(p, api.EnvironmentPath, "*", api.VmPath, "*", api.InterfacePath):
+		s.interfacesHandler(w, r, p[1], p[3])
+	case match(p, api.EnvironmentPath, "*", api.VmPath, "*", api.InterfacePath, "*"):
+		s.interfaceHandler(w, r, p[1], p[3], p[5])
+	case match(p, api.EnvironmentPath, "*", api.VmPath, "*", api.InterfacePath, "*", api.PublishedServicePath):
+		s.servicesHandler(w, r, p[1], p[3], p[5])
+	case match(p, api.TemplatePath):
+		s.templatesHandler(w, r)
+	case match(p, api.TemplatePath, "*"):
+		s.templateHandler(w, r, p[1])
+	case match(p, api.TemplatePath, "*", api.VmPath, "*"):
+		s.templateVmHandler(w, r, p[1], p[3])
+	case match(p, api.VmPath, "*"):
+		s.vmHandler(w, r, p[1])
+	case match(p, api.VmPath, "*", "credentials"):
+		s.credentialsHandler(w, r, p[1])
+	case match(p, api.VpnPath):
+		s.vpnsHandler(w, r)
+	case match(p, api.VpnPath, "*"):
+		s.vpnHandler(w, r, p[1])
+	default:
+		writeError(w, http.StatusNotFound, "No route for "+r.URL.Path)
+	}
+}
+
+/*
+ True if the path segments match the pattern, where "*" matches any single segment.
+*/
+func match(path []string, pattern ...string) bool {
+	if len(path) != len(pattern) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ Writes the slice of items selected by the count and offset query parameters, with a Content-Range header.
+*/
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count <= 0 {
+		count = api.DefaultPageSize
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := offset + count
+	if end > len(items) {
+		end = len(items)
+	}
+	if end > offset {
+		w.Header().Set("Content-Range", fmt.Sprintf("items %d-%d/%d", offset, end-1, len(items)))
+	}
+	writeJson(w, http.StatusOK, items[offset:end])
+}
+
+func decodeBody(r *http.Request, body interface{}) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(body)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s not supported for %s", r.Method, r.URL.Path))
+}
+
+func notFound(w http.ResponseWriter, kind string, id string) {
+	writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", kind, id))
+}
+
+func (s *Server) writeEnvironment(w http.ResponseWriter, env *api.Environment) {
+	env.Runstate = environmentRunstate(env)
+	writeJson(w, http.StatusOK, env)
+}
+
+func (s *Server) environmentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listEnvironments(w, r)
+	case http.MethodPost:
+		s.createEnvironment(w, r)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+/*
+ Lists environment summaries. The name, region and runstate query terms are applied, other terms are ignored.
+*/
+func (s *Server) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	terms := queryTerms(r)
+	ids := make([]string, 0, len(s.environments))
+	for id := range s.environments {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	summaries := []*api.Environment{}
+	for _, id := range ids {
+		env := s.environments[id]
+		runstate := environmentRunstate(env)
+		if !matchesNameAndRegion(terms, env.Name, env.Region) {
+			continue
+		}
+		if state, ok := terms["runstate"]; ok && runstate != state {
+			continue
+		}
+		summaries = append(summaries, &api.Environment{
+			Id:          env.Id,
+			Url:         env.Url,
+			Name:        env.Name,
+			Description: env.Description,
+			Runstate:    runstate,
+			Region:      env.Region,
+			VmCount:     len(env.Vms),
+		})
+	}
+	writePage(w, r, summaries)
+}
+
+/*
+ The terms of the list query parameter, "name:web,region:US-West", as a map.
+*/
+func queryTerms(r *http.Request) map[string]string {
+	terms := map[string]string{}
+	if query := r.URL.Query().Get("query"); query != "" {
+		for _, term := range strings.Split(query, ",") {
+			if kv := strings.SplitN(term, ":", 2); len(kv) == 2 {
+				terms[kv[0]] = kv[1]
+			}
+		}
+	}
+	return terms
+}
+
+func matchesNameAndRegion(terms map[string]string, name string, region string) bool {
+	if search, ok := terms["name"]; ok && !strings.Contains(strings.ToLower(name), strings.ToLower(search)) {
+		return false
+	}
+	if want, ok := terms["region"]; ok && region != want {
+		return false
+	}
+	return true
+}
+
+func (s *Server) createEnvironment(w http.ResponseWriter, r *http.Request) {
+
+	body := struct {
+		TemplateId    string   `json:"template_id"`
+		EnvironmentId string   `json:"configuration_id"`
+		VmIds         []string `json:"vm_ids"`
+	}{}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var name, region string
+	var source []*api.VirtualMachine
+	if body.TemplateId != "" {
+		t, ok := s.templates[body.TemplateId]
+		if !ok {
+			notFound(w, "Template", body.TemplateId)
+			return
+		}
+		name, region, source = t.Name, t.Region, t.Vms
+	} else if body.EnvironmentId != "" {
+		env, ok := s.environments[body.EnvironmentId]
+		if !ok {
+			notFound(w, "Environment", body.EnvironmentId)
+			return
+		}
+		name, region, source = env.Name+" copy", env.Region, env.Vms
+	} else {
+		writeError(w, http.StatusUnprocessableEntity, "template_id or configuration_id is required")
+		return
+	}
+
+	env := s.newEnvironment(name)
+	env.Region = region
+	if err := s.copyVmsInto(env, source, body.VmIds); err != nil {
+		delete(s.environments, env.Id)
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.writeEnvironment(w, env)
+}
+
+func (s *Server) environmentHandler(w http.ResponseWriter, r *http.Request, envId string) {
+	env, ok := s.environments[envId]
+	if !ok {
+		notFound(w, "Environment", envId)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		for _, vm := range env.Vms {
+			s.tick(vm)
+		}
+		s.writeEnvironment(w, env)
+
+	case http.MethodPut:
+		body := map[string]json.RawMessage{}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if environmentBusy(env) {
+			writeError(w, http.StatusLocked, fmt.Sprintf("Environment %s is busy", envId))
+			return
+		}
+		if status, message := s.updateEnvironment(env, body); status != http.StatusOK {
+			writeError(w, status, message)
+			return
+		}
+		s.writeEnvironment(w, env)
+
+	case http.MethodDelete:
+		if environmentBusy(env) {
+			writeError(w, http.StatusLocked, fmt.Sprintf("Environment %s is busy", envId))
+			return
+		}
+		for _, vm := range env.Vms {
+			delete(s.vms, vm.Id)
+			delete(s.vmParent, vm.Id)
+		}
+		delete(s.environments, envId)
+		writeJson(w, http.StatusOK, struct{}{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) updateEnvironment(env *api.Environment, body map[string]json.RawMessage) (int, string) {
+	var str string
+	var ids []string
+
+	if raw, ok := body["name"]; ok && json.Unmarshal(raw, &str) == nil {
+		env.Name = str
+	}
+	if raw, ok := body["description"]; ok && json.Unmarshal(raw, &str) == nil {
+		env.Description = str
+	}
+	if raw, ok := body["owner"]; ok && json.Unmarshal(raw, &str) == nil {
+		env.Owner = s.URL + "/users/" + str
+	}
+	if raw, ok := body["suspend_on_idle"]; ok {
+		env.SuspendOnIdle = nil
+		json.Unmarshal(raw, &env.SuspendOnIdle)
+	}
+	if raw, ok := body["suspend_at_time"]; ok {
+		env.SuspendAtTime = nil
+		json.Unmarshal(raw, &env.SuspendAtTime)
+	}
+	if raw, ok := body["routable"]; ok {
+		json.Unmarshal(raw, &env.Routable)
+	}
+	if raw, ok := body["disable_internet"]; ok {
+		json.Unmarshal(raw, &env.DisableInternet)
+	}
+
+	if raw, ok := body["runstate"]; ok && json.Unmarshal(raw, &str) == nil {
+		for _, vm := range env.Vms {
+			if str == api.RunStatePause && vm.Runstate != api.RunStateStart {
+				continue
+			}
+			if status, message := s.changeRunstate(vm, str); status != http.StatusOK {
+				return status, message
+			}
+		}
+	}
+
+	if raw, ok := body["vm_ids"]; ok {
+		json.Unmarshal(raw, &ids)
+	}
+	if raw, ok := body["template_id"]; ok && json.Unmarshal(raw, &str) == nil {
+		t, ok := s.templates[str]
+		if !ok {
+			return http.StatusNotFound, fmt.Sprintf("Template %s not found", str)
+		}
+		if err := s.copyVmsInto(env, t.Vms, ids); err != nil {
+			return http.StatusUnprocessableEntity, err.Error()
+		}
+	}
+	if raw, ok := body["merge_configuration"]; ok && json.Unmarshal(raw, &str) == nil {
+		source, ok := s.environments[str]
+		if !ok {
+			return http.StatusNotFound, fmt.Sprintf("Environment %s not found", str)
+		}
+		if err := s.copyVmsInto(env, source.Vms, ids); err != nil {
+			return http.StatusUnprocessableEntity, err.Error()
+		}
+	}
+	return http.StatusOK, ""
+}
+
+func (s *Server) environmentVmHandler(w http.ResponseWriter, r *http.Request, envId string, vmId string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	vm, ok := s.vms[vmId]
+	if !ok || s.vmParent[vmId] != "configurations/"+envId {
+		notFound(w, "VM", vmId)
+		return
+	}
+	s.tick(vm)
+	writeJson(w, http.StatusOK, vm)
+}
+
+func (s *Server) environmentVmsHandler(w http.ResponseWriter, r *http.Request, envId string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	env, ok := s.environments[envId]
+	if !ok {
+		notFound(w, "Environment", envId)
+		return
+	}
+	for _, vm := range env.Vms {
+		s.tick(vm)
+	}
+	writePage(w, r, env.Vms)
+}
+
+/*
+ Lists template summaries. The name and region query terms are applied, other terms and the scope are ignored.
+*/
+func (s *Server) templatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listTemplates(w, r)
+	case http.MethodPost:
+		s.createTemplate(w, r)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) listTemplates(w http.ResponseWriter, r *http.Request) {
+	terms := queryTerms(r)
+	ids := make([]string, 0, len(s.templates))
+	for id := range s.templates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	summaries := []*api.Template{}
+	for _, id := range ids {
+		t := s.templates[id]
+		if !matchesNameAndRegion(terms, t.Name, t.Region) {
+			continue
+		}
+		summary := *t
+		summary.Vms, summary.Networks, summary.VmCount = nil, nil, len(t.Vms)
+		summaries = append(summaries, &summary)
+	}
+	writePage(w, r, summaries)
+}
+
+/*
+ Creates a template from an environment, which stays busy for BusyPolls reads.
+*/
+func (s *Server) createTemplate(w http.ResponseWriter, r *http.Request) {
+	body := api.CreateTemplateBody{}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	env, ok := s.environments[body.EnvironmentId]
+	if !ok {
+		notFound(w, "Environment", body.EnvironmentId)
+		return
+	}
+	if environmentBusy(env) {
+		writeError(w, http.StatusLocked, fmt.Sprintf("Environment %s is busy", env.Id))
+		return
+	}
+
+	id := s.newId()
+	t := &api.Template{
+		Id:          id,
+		Url:         s.URL + "/templates/" + id,
+		Name:        env.Name,
+		Description: env.Description,
+		Region:      env.Region,
+		LockVersion: s.nextLockVersion(),
+	}
+	if err := s.copyVmsIntoTemplate(t, env.Vms, body.VmIds); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.templates[id] = t
+	s.makeTemplateBusy(t)
+	writeJson(w, http.StatusOK, t)
+}
+
+func (s *Server) templateHandler(w http.ResponseWriter, r *http.Request, templateId string) {
+	t, ok := s.templates[templateId]
+	if !ok {
+		notFound(w, "Template", templateId)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.tickTemplate(t)
+		writeJson(w, http.StatusOK, t)
+
+	case http.MethodPut:
+		body := struct {
+			api.TemplateUpdate
+			MergeEnvironmentId string   `json:"merge_configuration"`
+			VmIds              []string `json:"vm_ids"`
+		}{}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.checkTemplateLock(w, t, body.LockVersion) {
+			return
+		}
+
+		if body.MergeEnvironmentId != "" {
+			env, ok := s.environments[body.MergeEnvironmentId]
+			if !ok {
+				notFound(w, "Environment", body.MergeEnvironmentId)
+				return
+			}
+			if err := s.copyVmsIntoTemplate(t, env.Vms, body.VmIds); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+			s.makeTemplateBusy(t)
+		}
+		if body.Name != nil {
+			t.Name = *body.Name
+		}
+		if body.Description != nil {
+			t.Description = *body.Description
+		}
+		if body.Public != nil {
+			t.Public = *body.Public
+		}
+		if body.Shared != nil {
+			t.Shared = *body.Shared
+		}
+		t.LockVersion = s.nextLockVersion()
+		writeJson(w, http.StatusOK, t)
+
+	case http.MethodDelete:
+		if !s.checkTemplateLock(w, t, r.URL.Query().Get("lockversion")) {
+			return
+		}
+		for _, vm := range t.Vms {
+			delete(s.vms, vm.Id)
+			delete(s.vmParent, vm.Id)
+		}
+		delete(s.templates, templateId)
+		writeJson(w, http.StatusOK, struct{}{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+/*
+ Rejects changes to a busy template, or one that has moved on from the expected lock version.
+*/
+func (s *Server) checkTemplateLock(w http.ResponseWriter, t *api.Template, lockVersion string) bool {
+	if t.IsBusy() {
+		writeError(w, http.StatusLocked, fmt.Sprintf("Template %s is busy", t.Id))
+		return false
+	}
+	if lockVersion != "" && lockVersion != t.LockVersion {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Template %s has been changed, lockversion is %s", t.Id, t.LockVersion))
+		return false
+	}
+	return true
+}
+
+func (s *Server) templateVmHandler(w http.ResponseWriter, r *http.Request, templateId string, vmId string) {
+	t, ok := s.templates[templateId]
+	vm, vmOk := s.vms[vmId]
+	if !ok || !vmOk || s.vmParent[vmId] != "templates/"+templateId {
+		notFound(w, "VM", vmId)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, vm)
+
+	case http.MethodDelete:
+		if !s.checkTemplateLock(w, t, r.URL.Query().Get("lockversion")) {
+			return
+		}
+		for i, templateVm := range t.Vms {
+			if templateVm.Id == vmId {
+				t.Vms = append(t.Vms[:i], t.Vms[i+1:]...)
+				break
+			}
+		}
+		delete(s.vms, vmId)
+		delete(s.vmParent, vmId)
+		t.LockVersion = s.nextLockVersion()
+		writeJson(w, http.StatusOK, struct{}{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) vmHandler(w http.ResponseWriter, r *http.Request, vmId string) {
+	vm, ok := s.vms[vmId]
+	if !ok {
+		notFound(w, "VM", vmId)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.tick(vm)
+		writeJson(w, http.StatusOK, vm)
+
+	case http.MethodPut:
+		body := struct {
+			Runstate string `json:"runstate"`
+			Hardware *struct {
+				Cpus          *int `json:"cpus"`
+				CpusPerSocket *int `json:"cpus_per_socket"`
+				Ram           *int `json:"ram"`
+				Disks         *struct {
+					New      []int `json:"new"`
+					Existing map[string]struct {
+						Size *int `json:"size"`
+					} `json:"existing"`
+				} `json:"disks"`
+			} `json:"hardware"`
+		}{}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if vm.Runstate == api.RunStateBusy {
+			writeError(w, http.StatusLocked, fmt.Sprintf("VM %s is busy", vmId))
+			return
+		}
+
+		if name := r.URL.Query().Get("name"); name != "" {
+			vm.Name = name
+		}
+		if body.Runstate != "" {
+			if status, message := s.changeRunstate(vm, body.Runstate); status != http.StatusOK {
+				writeError(w, status, message)
+				return
+			}
+		}
+		if hw := body.Hardware; hw != nil {
+			if vm.Runstate != api.RunStateStop {
+				writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("VM %s must be stopped to change hardware", vmId))
+				return
+			}
+			if hw.Cpus != nil {
+				vm.Hardware.Cpus = hw.Cpus
+			}
+			if hw.CpusPerSocket != nil {
+				vm.Hardware.CpusPerSocket = hw.CpusPerSocket
+			}
+			if hw.Ram != nil {
+				vm.Hardware.Ram = hw.Ram
+			}
+			if hw.Disks != nil {
+				for _, size := range hw.Disks.New {
+					size := size
+					diskId := fmt.Sprintf("disk-%s-%d", vmId, len(vm.Hardware.Disks))
+					vm.Hardware.Disks = append(vm.Hardware.Disks, api.Disk{Id: diskId, Size: &size, Type: "SCSI", Controller: "0", Lun: fmt.Sprint(len(vm.Hardware.Disks))})
+				}
+				for i := range vm.Hardware.Disks {
+					if existing, ok := hw.Disks.Existing[vm.Hardware.Disks[i].Id]; ok && existing.Size != nil {
+						vm.Hardware.Disks[i].Size = existing.Size
+					}
+				}
+			}
+		}
+		writeJson(w, http.StatusOK, vm)
+
+	case http.MethodDelete:
+		if vm.Runstate == api.RunStateBusy {
+			writeError(w, http.StatusLocked, fmt.Sprintf("VM %s is busy", vmId))
+			return
+		}
+		parent := s.vmParent[vmId]
+		if strings.HasPrefix(parent, "configurations/") {
+			env := s.environments[strings.TrimPrefix(parent, "configurations/")]
+			for i, envVm := range env.Vms {
+				if envVm.Id == vmId {
+					env.Vms = append(env.Vms[:i], env.Vms[i+1:]...)
+					break
+				}
+			}
+		}
+		delete(s.vms, vmId)
+		delete(s.vmParent, vmId)
+		writeJson(w, http.StatusOK, struct{}{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) credentialsHandler(w http.ResponseWriter, r *http.Request, vmId string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if _, ok := s.vms[vmId]; !ok {
+		notFound(w, "VM", vmId)
+		return
+	}
+	writeJson(w, http.StatusOK, []api.VmCredential{{Id: vmId + "-1", Text: "root / ChangeMe!"}})
+}
+
+/*
+ Looks up a VM within an environment, writing a not found response if either doesn't exist.
+*/
+func (s *Server) environmentVm(w http.ResponseWriter, envId string, vmId string) *api.VirtualMachine {
+	if _, ok := s.environments[envId]; !ok {
+		notFound(w, "Environment", envId)
+		return nil
+	}
+	vm, ok := s.vms[vmId]
+	if !ok || s.vmParent[vmId] != "configurations/"+envId {
+		notFound(w, "VM", vmId)
+		return nil
+	}
+	return vm
+}
+
+func (s *Server) interfacesHandler(w http.ResponseWriter, r *http.Request, envId string, vmId string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	vm := s.environmentVm(w, envId, vmId)
+	if vm == nil {
+		return
+	}
+
+	nic := &api.NetworkInterface{}
+	if err := decodeBody(r, nic); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	nic.Id = fmt.Sprintf("nic-%s-%s", vmId, s.newId())
+	nic.Status = "Powered off"
+	vm.Interfaces = append(vm.Interfaces, nic)
+	writeJson(w, http.StatusOK, nic)
+}
+
+func (s *Server) interfaceHandler(w http.ResponseWriter, r *http.Request, envId string, vmId string, nicId string) {
+	vm := s.environmentVm(w, envId, vmId)
+	if vm == nil {
+		return
+	}
+	index := -1
+	for i, nic := range vm.Interfaces {
+		if nic.Id == nicId {
+			index = i
+		}
+	}
+	if index < 0 {
+		notFound(w, "Interface", nicId)
+		return
+	}
+	nic := vm.Interfaces[index]
+
+	switch r.Method {
+	case http.MethodPut:
+		update := &api.NetworkInterface{}
+		if err := decodeBody(r, update); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if update.Hostname != "" {
+			nic.Hostname = update.Hostname
+		}
+		if update.Ip != "" {
+			nic.Ip = update.Ip
+		}
+		if update.NetworkId != "" {
+			nic.NetworkId = update.NetworkId
+		}
+		writeJson(w, http.StatusOK, nic)
+
+	case http.MethodDelete:
+		vm.Interfaces = append(vm.Interfaces[:index], vm.Interfaces[index+1:]...)
+		writeJson(w, http.StatusOK, struct{}{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) servicesHandler(w http.ResponseWriter, r *http.Request, envId string, vmId string, nicId string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	vm := s.environmentVm(w, envId, vmId)
+	if vm == nil {
+		return
+	}
+	for _, nic := range vm.Interfaces {
+		if nic.Id != nicId {
+			continue
+		}
+		service := api.PublishedService{}
+		if err := decodeBody(r, &service); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		service.Id = s.newId()
+		service.ExternalIp = "203.0.113.10"
+		service.ExternalPort = 20000 + len(nic.PublishedServices)
+		nic.PublishedServices = append(nic.PublishedServices, service)
+		writeJson(w, http.StatusOK, service)
+		return
+	}
+	notFound(w, "Interface", nicId)
+}
+
+func (s *Server) networksHandler(w http.ResponseWriter, r *http.Request, envId string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	env, ok := s.environments[envId]
+	if !ok {
+		notFound(w, "Environment", envId)
+		return
+	}
+
+	network := api.Network{}
+	if err := decodeBody(r, &network); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if network.Subnet == "" {
+		writeError(w, http.StatusUnprocessableEntity, "subnet is required")
+		return
+	}
+	network.Id = s.newId()
+	network.Url = fmt.Sprintf("%s/configurations/%s/networks/%s", s.URL, envId, network.Id)
+	env.Networks = append(env.Networks, network)
+	writeJson(w, http.StatusOK, network)
+}
+
+func (s *Server) environmentNetwork(w http.ResponseWriter, envId string, netId string) *api.Network {
+	env, ok := s.environments[envId]
+	if !ok {
+		notFound(w, "Environment", envId)
+		return nil
+	}
+	for i := range env.Networks {
+		if env.Networks[i].Id == netId {
+			return &env.Networks[i]
+		}
+	}
+	notFound(w, "Network", netId)
+	return nil
+}
+
+func (s *Server) networkHandler(w http.ResponseWriter, r *http.Request, envId string, netId string) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+	if s.environmentNetwork(w, envId, netId) == nil {
+		return
+	}
+	env := s.environments[envId]
+	for i := range env.Networks {
+		if env.Networks[i].Id == netId {
+			env.Networks = append(env.Networks[:i], env.Networks[i+1:]...)
+			break
+		}
+	}
+	writeJson(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) networkVpnsHandler(w http.ResponseWriter, r *http.Request, envId string, netId string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	network := s.environmentNetwork(w, envId, netId)
+	if network == nil {
+		return
+	}
+
+	body := api.AttachVpnBody{}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	vpn, ok := s.vpns[body.VpnId]
+	if !ok {
+		notFound(w, "VPN", body.VpnId)
+		return
+	}
+	for _, attachment := range network.VpnAttachments {
+		if attachment.Vpn.Id == vpn.Id {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Network %s is already attached to %s", netId, vpn.Id))
+			return
+		}
+	}
+
+	attachment := api.VpnAttachment{Id: netId + "-" + vpn.Id, Vpn: *vpn}
+	network.VpnAttachments = append(network.VpnAttachments, attachment)
+	writeJson(w, http.StatusOK, &api.AttachVpnResult{Id: attachment.Id, Vpn: vpn})
+}
+
+func (s *Server) networkVpnHandler(w http.ResponseWriter, r *http.Request, envId string, netId string, vpnId string) {
+	network := s.environmentNetwork(w, envId, netId)
+	if network == nil {
+		return
+	}
+	index := -1
+	for i, attachment := range network.VpnAttachments {
+		if attachment.Vpn.Id == vpnId {
+			index = i
+		}
+	}
+	if index < 0 {
+		notFound(w, "VPN attachment", vpnId)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body := api.ConnectVpnBody{}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		network.VpnAttachments[index].Connected = body.Connected
+		attachment := network.VpnAttachments[index]
+		writeJson(w, http.StatusOK, &api.AttachVpnResult{Id: attachment.Id, Connected: attachment.Connected, Vpn: attachment.Vpn})
+
+	case http.MethodDelete:
+		network.VpnAttachments = append(network.VpnAttachments[:index], network.VpnAttachments[index+1:]...)
+		writeJson(w, http.StatusOK, struct{}{})
+
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) vpnsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	ids := make([]string, 0, len(s.vpns))
+	for id := range s.vpns {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	vpns := make([]*api.Vpn, len(ids))
+	for i, id := range ids {
+		vpns[i] = s.vpns[id]
+	}
+	writePage(w, r, vpns)
+}
+
+func (s *Server) vpnHandler(w http.ResponseWriter, r *http.Request, vpnId string) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	vpn, ok := s.vpns[vpnId]
+	if !ok {
+		notFound(w, "VPN", vpnId)
+		return
+	}
+	writeJson(w, http.StatusOK, vpn)
+}
@@ -0,0 +1,592 @@
+// Copyright 2016 Skytap Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+ Package skytaptest provides an in-memory, stateful fake of the skytap API for testing code built on the SDK without
+ a skytap account.
+
+	server := skytaptest.NewServer()
+	defer server.Close()
+
+	template := server.AddTemplate("Golden image", "US-West", "web", "db")
+	env, err := api.CreateNewEnvironment(*server.Client(), template.Id)
+
+ In tests, NewTestServer starts a server that is closed when the test ends, along with its client.
+*/
+package skytaptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skytap/skytap-sdk-go/api"
+)
+
+/*
+ A scripted failure, returned instead of the normal response for matching requests.
+*/
+type Fault struct {
+	// HTTP method to match, empty matches any method.
+	Method string
+	// Substring of the request path to match, empty matches any path.
+	Path string
+	// Status code to respond with.
+	Status int
+	// Error message in the response body.
+	Message string
+	// Value of the Retry-After header in seconds, omitted if zero.
+	RetryAfter int
+	// Number of matching requests to fail, zero or less fails every matching request.
+	Times int
+}
+
+/*
+ Fake skytap API server. Its exported fields may be changed between requests, but not while requests are in flight.
+*/
+type Server struct {
+	// Root URL of the fake, pass it to api.WithBaseUrl or use Client.
+	URL string
+	// Number of reads a VM stays busy for after a runstate change, zero completes changes immediately.
+	BusyPolls int
+
+	server *httptest.Server
+
+	mu            sync.Mutex
+	nextId        int
+	lockVersions  int
+	environments  map[string]*api.Environment
+	templates     map[string]*api.Template
+	vms           map[string]*api.VirtualMachine
+	vmParent      map[string]string
+	transitions   map[string]*transition
+	busyTemplates map[string]int
+	withoutTools  map[string]bool
+	ignoreStop    map[string]bool
+	vpns          map[string]*api.Vpn
+	faults        []*Fault
+	requests      []string
+}
+
+type transition struct {
+	target    string
+	remaining int
+}
+
+/*
+ Start a new, empty fake server. Close it when done.
+*/
+func NewServer() *Server {
+	s := &Server{
+		nextId:        1000,
+		environments:  map[string]*api.Environment{},
+		templates:     map[string]*api.Template{},
+		vms:           map[string]*api.VirtualMachine{},
+		vmParent:      map[string]string{},
+		transitions:   map[string]*transition{},
+		busyTemplates: map[string]int{},
+		withoutTools:  map[string]bool{},
+		ignoreStop:    map[string]bool{},
+		vpns:          map[string]*api.Vpn{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+/*
+ Shut down the server.
+*/
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+/*
+ Start a server for a test, which is closed when the test ends, and create a client for it. See Client for the options.
+*/
+func NewTestServer(t testing.TB, opts ...api.ClientOption) (*Server, api.SkytapClient) {
+	s := NewServer()
+	t.Cleanup(s.Close)
+	return s, *s.Client(opts...)
+}
+
+/*
+ Create a client talking to this server, with retries and polling that don't slow tests down. Options are applied afterwards
+ and may override these defaults.
+*/
+func (s *Server) Client(opts ...api.ClientOption) *api.SkytapClient {
+	defaults := []api.ClientOption{
+		api.WithCredentials("skytaptest", "skytaptest"),
+		api.WithBaseUrl(s.URL),
+		api.WithRetryPolicy(&api.BackoffRetryPolicy{
+			MaxRetries:      5,
+			InitialInterval: 5 * time.Millisecond,
+			MaxInterval:     50 * time.Millisecond,
+			Multiplier:      2,
+		}),
+		api.WithWaitOptions(&api.WaitOptions{
+			InitialInterval: time.Millisecond,
+			Multiplier:      2,
+			MaxInterval:     20 * time.Millisecond,
+			Timeout:         10 * time.Second,
+		}),
+	}
+	return api.NewClient(append(defaults, opts...)...)
+}
+
+/*
+ Fail requests matching the given fault, until it has been used up.
+*/
+func (s *Server) AddFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := fault
+	s.faults = append(s.faults, &f)
+}
+
+/*
+ Remove all configured faults.
+*/
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+/*
+ Requests received so far, as "METHOD /path" strings.
+*/
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+/*
+ Add a template with stopped VMs of the given names.
+*/
+func (s *Server) AddTemplate(name string, region string, vmNames ...string) *api.Template {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newId()
+	t := &api.Template{Id: id, Url: s.URL + "/templates/" + id, Name: name, Region: region, LockVersion: s.nextLockVersion()}
+	for _, vmName := range vmNames {
+		vm := s.newVm(vmName)
+		vm.TemplateUrl = t.Url
+		s.vmParent[vm.Id] = "templates/" + id
+		t.Vms = append(t.Vms, vm)
+	}
+	s.templates[id] = t
+	return copyTemplate(t)
+}
+
+/*
+ Add an empty, stopped environment.
+*/
+func (s *Server) AddEnvironment(name string) *api.Environment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env := s.newEnvironment(name)
+	return copyEnvironment(env)
+}
+
+/*
+ Add a VPN which environment networks can be attached to.
+*/
+func (s *Server) AddVpn(name string) *api.Vpn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vpn := &api.Vpn{Id: "vpn-" + s.newId(), Name: name, Enabled: true}
+	s.vpns[vpn.Id] = vpn
+	result := *vpn
+	return &result
+}
+
+/*
+ Sets whether a VM has VMware tools loaded, which it does by default. Graceful shutdowns of a VM without tools are
+ accepted but leave it running with an error, like skytap does.
+*/
+func (s *Server) SetToolsLoaded(vmId string, loaded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if loaded {
+		delete(s.withoutTools, vmId)
+	} else {
+		s.withoutTools[vmId] = true
+	}
+}
+
+/*
+ Sets whether a VM's guest ignores graceful shutdowns. Shutdowns of such a VM are accepted, and it goes busy for
+ BusyPolls reads as usual, but then returns to running without any error.
+*/
+func (s *Server) SetShutdownIgnored(vmId string, ignored bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ignored {
+		s.ignoreStop[vmId] = true
+	} else {
+		delete(s.ignoreStop, vmId)
+	}
+}
+
+/*
+ Current state of an environment, nil if it doesn't exist. Reading state this way doesn't advance transitions.
+*/
+func (s *Server) Environment(id string) *api.Environment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env, ok := s.environments[id]
+	if !ok {
+		return nil
+	}
+	env.Runstate = environmentRunstate(env)
+	return copyEnvironment(env)
+}
+
+/*
+ Current state of a template, nil if it doesn't exist.
+*/
+func (s *Server) Template(id string) *api.Template {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.templates[id]
+	if !ok {
+		return nil
+	}
+	return copyTemplate(t)
+}
+
+/*
+ Current state of a VM, nil if it doesn't exist. Reading state this way doesn't advance transitions.
+*/
+func (s *Server) VirtualMachine(id string) *api.VirtualMachine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vm, ok := s.vms[id]
+	if !ok {
+		return nil
+	}
+	result := &api.VirtualMachine{}
+	deepCopy(vm, result)
+	return result
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if _, _, ok := r.BasicAuth(); !ok {
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	if fault := s.matchFault(r); fault != nil {
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(fault.RetryAfter))
+		}
+		message := fault.Message
+		if message == "" {
+			message = http.StatusText(fault.Status)
+		}
+		writeError(w, fault.Status, message)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".json")
+	s.route(w, r, strings.Split(path, "/"))
+}
+
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for i, fault := range s.faults {
+		if fault.Method != "" && fault.Method != r.Method {
+			continue
+		}
+		if fault.Path != "" && !strings.Contains(r.URL.Path, fault.Path) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+func (s *Server) newId() string {
+	s.nextId++
+	return strconv.Itoa(s.nextId)
+}
+
+func (s *Server) newVm(name string) *api.VirtualMachine {
+	cpus, perSocket, ram := 1, 1, 1024
+	vm := &api.VirtualMachine{
+		Id:       s.newId(),
+		Name:     name,
+		Runstate: api.RunStateStop,
+		Error:    false,
+		Hardware: api.Hardware{Cpus: &cpus, CpusPerSocket: &perSocket, Ram: &ram},
+	}
+	s.vms[vm.Id] = vm
+	return vm
+}
+
+func (s *Server) newEnvironment(name string) *api.Environment {
+	id := s.newId()
+	env := &api.Environment{Id: id, Url: s.URL + "/configurations/" + id, Name: name, Runstate: api.RunStateStop}
+	s.environments[id] = env
+	return env
+}
+
+/*
+ Copies VMs into an environment, with new ids. If vmIds is empty all VMs are copied.
+*/
+func (s *Server) copyVmsInto(env *api.Environment, source []*api.VirtualMachine, vmIds []string) error {
+	copies, err := s.copyVms(source, vmIds, "configurations/"+env.Id)
+	for _, vm := range copies {
+		vm.EnvironmentUrl = env.Url
+	}
+	env.Vms = append(env.Vms, copies...)
+	return err
+}
+
+/*
+ Copies VMs into a template, with new ids. If vmIds is empty all VMs are copied.
+*/
+func (s *Server) copyVmsIntoTemplate(t *api.Template, source []*api.VirtualMachine, vmIds []string) error {
+	copies, err := s.copyVms(source, vmIds, "templates/"+t.Id)
+	for _, vm := range copies {
+		vm.TemplateUrl = t.Url
+	}
+	t.Vms = append(t.Vms, copies...)
+	return err
+}
+
+func (s *Server) copyVms(source []*api.VirtualMachine, vmIds []string, parent string) ([]*api.VirtualMachine, error) {
+	for _, id := range vmIds {
+		if !containsVm(source, id) {
+			return nil, fmt.Errorf("VM %s is not part of the source", id)
+		}
+	}
+	var copies []*api.VirtualMachine
+	for _, vm := range source {
+		if len(vmIds) > 0 && !contains(vmIds, vm.Id) {
+			continue
+		}
+		copied := &api.VirtualMachine{}
+		deepCopy(vm, copied)
+		copied.Id = s.newId()
+		copied.Runstate = api.RunStateStop
+		copied.TemplateUrl = ""
+		copied.EnvironmentUrl = ""
+		s.vms[copied.Id] = copied
+		s.vmParent[copied.Id] = parent
+		copies = append(copies, copied)
+	}
+	return copies, nil
+}
+
+/*
+ Advances any runstate transition of the VM by one read.
+*/
+func (s *Server) tick(vm *api.VirtualMachine) {
+	t, ok := s.transitions[vm.Id]
+	if !ok {
+		return
+	}
+	t.remaining--
+	if t.remaining <= 0 {
+		vm.Runstate = t.target
+		delete(s.transitions, vm.Id)
+	}
+}
+
+/*
+ Counts down a busy template by one read.
+*/
+func (s *Server) tickTemplate(t *api.Template) {
+	remaining, ok := s.busyTemplates[t.Id]
+	if !ok {
+		return
+	}
+	if remaining <= 1 {
+		delete(s.busyTemplates, t.Id)
+		t.Busy = nil
+		return
+	}
+	s.busyTemplates[t.Id] = remaining - 1
+}
+
+/*
+ Marks a template busy for BusyPolls reads, if BusyPolls is set.
+*/
+func (s *Server) makeTemplateBusy(t *api.Template) {
+	if s.BusyPolls <= 0 {
+		return
+	}
+	busy := true
+	t.Busy = &busy
+	s.busyTemplates[t.Id] = s.BusyPolls
+}
+
+/*
+ Starts a runstate change, failing if the change isn't valid from the VM's current state.
+*/
+func (s *Server) changeRunstate(vm *api.VirtualMachine, runstate string) (int, string) {
+	if vm.Runstate == api.RunStateBusy {
+		return http.StatusLocked, fmt.Sprintf("VM %s is busy", vm.Id)
+	}
+
+	target, ignored := runstate, false
+	switch runstate {
+	case api.RunStateStart, api.RunStateReset:
+		target = api.RunStateStart
+	case api.RunStateStop:
+		if vm.Runstate == api.RunStatePause {
+			return http.StatusUnprocessableEntity, fmt.Sprintf("VM %s is suspended and cannot be stopped", vm.Id)
+		}
+		if vm.Runstate == api.RunStateStart && s.withoutTools[vm.Id] {
+			vm.Error = "VMware Tools are not running, the VM could not be shut down"
+			return http.StatusOK, ""
+		}
+		if vm.Runstate == api.RunStateStart && s.ignoreStop[vm.Id] {
+			target, ignored = api.RunStateStart, true
+		}
+	case api.RunStatePause:
+		if vm.Runstate != api.RunStateStart {
+			return http.StatusUnprocessableEntity, fmt.Sprintf("VM %s is not running and cannot be suspended", vm.Id)
+		}
+	case api.RunStateKill:
+		if vm.Runstate == api.RunStatePause {
+			return http.StatusUnprocessableEntity, fmt.Sprintf("VM %s is suspended and cannot be halted", vm.Id)
+		}
+		target = api.RunStateStop
+	default:
+		return http.StatusUnprocessableEntity, fmt.Sprintf("Unknown runstate %s", runstate)
+	}
+
+	vm.Error = false
+	if target == vm.Runstate && runstate != api.RunStateReset && !ignored {
+		return http.StatusOK, ""
+	}
+	if s.BusyPolls <= 0 {
+		vm.Runstate = target
+		return http.StatusOK, ""
+	}
+	vm.Runstate = api.RunStateBusy
+	s.transitions[vm.Id] = &transition{target: target, remaining: s.BusyPolls}
+	return http.StatusOK, ""
+}
+
+func environmentRunstate(env *api.Environment) string {
+	if len(env.Vms) == 0 {
+		return api.RunStateStop
+	}
+	runstate := env.Vms[0].Runstate
+	for _, vm := range env.Vms {
+		if vm.Runstate == api.RunStateBusy {
+			return api.RunStateBusy
+		}
+		if vm.Runstate != runstate {
+			runstate = api.RunStateStart
+		}
+	}
+	return runstate
+}
+
+func environmentBusy(env *api.Environment) bool {
+	for _, vm := range env.Vms {
+		if vm.Runstate == api.RunStateBusy {
+			return true
+		}
+	}
+	return false
+}
+
+func copyTemplate(t *api.Template) *api.Template {
+	result := &api.Template{}
+	deepCopy(t, result)
+	return result
+}
+
+/*
+ A new lock version, in the 40 hex digit format skytap uses.
+*/
+func (s *Server) nextLockVersion() string {
+	s.lockVersions++
+	return fmt.Sprintf("%040x", s.lockVersions)
+}
+
+func copyEnvironment(env *api.Environment) *api.Environment {
+	result := &api.Environment{}
+	deepCopy(env, result)
+	return result
+}
+
+func deepCopy(from interface{}, to interface{}) {
+	b, err := json.Marshal(from)
+	if err != nil {
+		panic(err)
+	}
+	if err = json.Unmarshal(b, to); err != nil {
+		panic(err)
+	}
+}
+
+func containsVm(vms []*api.VirtualMachine, id string) bool {
+	for _, vm := range vms {
+		if vm.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,390 @@
+package skytaptest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dghubble/sling"
+	"github.com/skytap/skytap-sdk-go/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentLifecycle(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	template := server.AddTemplate("Golden image", "US-West", "web", "db")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	require.Equal(t, "Golden image", env.Name)
+	require.Equal(t, 2, len(env.Vms))
+	require.Equal(t, api.RunStateStop, env.Runstate)
+
+	env, err = env.Start(client)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, env.Runstate)
+
+	vm, err := api.GetVirtualMachine(client, env.Vms[0].Id)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, vm.Runstate)
+
+	vm, err = vm.Stop(client)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStop, vm.Runstate)
+
+	env, err = api.GetEnvironment(client, env.Id)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, env.Runstate, "A partly running environment should report running")
+
+	require.NoError(t, api.DeleteEnvironment(client, env.Id))
+	_, err = api.GetEnvironment(client, env.Id)
+	require.True(t, api.IsNotFound(err))
+	require.Nil(t, server.VirtualMachine(vm.Id), "Deleting an environment should delete its VMs")
+}
+
+func TestBusyTransitions(t *testing.T) {
+	server, client := NewTestServer(t, api.WithRetryPolicy(api.NoRetryPolicy{}))
+	server.BusyPolls = 2
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	vmId := env.Vms[0].Id
+
+	_, err = api.RunSkytapRequest(client, false, env, func(s *sling.Sling) *sling.Sling {
+		return s.Put("configurations/" + env.Id + ".json").BodyJSON(&api.RunstateBody{Runstate: api.RunStateStart})
+	})
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateBusy, server.VirtualMachine(vmId).Runstate)
+
+	err = api.DeleteEnvironment(client, env.Id)
+	require.True(t, api.IsBusy(err), "Deleting while busy should be rejected")
+
+	vm, err := api.GetVirtualMachine(client, vmId)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateBusy, vm.Runstate)
+	vm, err = api.GetVirtualMachine(client, vmId)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, vm.Runstate, "Transition should complete after BusyPolls reads")
+}
+
+func TestShutdownIgnored(t *testing.T) {
+	server, client := NewTestServer(t)
+	server.BusyPolls = 2
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	vm, err := env.Vms[0].Start(client)
+	require.NoError(t, err)
+
+	server.SetShutdownIgnored(vm.Id, true)
+	vm, err = vm.Stop(client)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStart, vm.Runstate, "The VM should be running again, without an error")
+
+	vm, err = vm.Kill(client)
+	require.NoError(t, err)
+	require.Equal(t, api.RunStateStop, vm.Runstate)
+}
+
+func TestInvalidTransition(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+
+	_, err = env.Vms[0].Suspend(client)
+	_, ok := err.(*api.RunstateTransitionError)
+	require.True(t, ok, "The SDK should refuse to suspend a stopped VM")
+
+	_, err = api.RunSkytapRequest(client, false, nil, func(s *sling.Sling) *sling.Sling {
+		return s.Put("vms/" + env.Vms[0].Id).BodyJSON(&api.RunstateBody{Runstate: api.RunStatePause})
+	})
+	require.True(t, api.IsValidation(err), "Suspending a stopped VM should fail validation")
+}
+
+func TestEnvironmentSettings(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	env := server.AddEnvironment("Build")
+	env, err := api.UpdateEnvironment(client, env.Id, &api.EnvironmentUpdate{
+		Name:          api.String("Nightly build"),
+		Owner:         api.String("42"),
+		SuspendOnIdle: api.Int(1800),
+		SuspendAtTime: api.String("2026/10/17 18:00:00 -0700"),
+		Routable:      api.Bool(true),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Nightly build", env.Name)
+	require.Equal(t, server.URL+"/users/42", env.Owner)
+	require.Equal(t, 1800, *env.SuspendOnIdle)
+	require.True(t, env.Routable)
+
+	env, err = env.Update(client, &api.EnvironmentUpdate{SuspendOnIdle: api.Int(0), DisableInternet: api.Bool(true)})
+	require.NoError(t, err)
+	require.Nil(t, env.SuspendOnIdle)
+	require.Equal(t, "2026/10/17 18:00:00 -0700", *env.SuspendAtTime, "Unset fields should be left alone")
+	require.True(t, server.Environment(env.Id).DisableInternet)
+}
+
+func TestFaults(t *testing.T) {
+	server, client := NewTestServer(t)
+	env := server.AddEnvironment("faulty")
+
+	server.AddFault(Fault{Method: "GET", Path: "/configurations/", Status: http.StatusLocked, Times: 2})
+	_, err := api.GetEnvironment(client, env.Id)
+	require.NoError(t, err, "Should succeed once the fault is used up")
+	require.Equal(t, 3, len(server.Requests()))
+
+	server.AddFault(Fault{Status: http.StatusInternalServerError, Message: "boom"})
+	_, err = api.CreateNewEnvironment(client, "1")
+	apiErr, ok := err.(*api.APIError)
+	require.True(t, ok)
+	require.Equal(t, 500, apiErr.StatusCode)
+	require.Equal(t, "boom", apiErr.Message())
+
+	server.ClearFaults()
+	_, err = api.GetEnvironment(client, env.Id)
+	require.NoError(t, err)
+}
+
+func TestRequiresAuthentication(t *testing.T) {
+	server, _ := NewTestServer(t)
+
+	resp, err := http.Get(server.URL + "/vms/1.json")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestNetworkAndVpn(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	env := server.AddEnvironment("networked")
+	vpn := server.AddVpn("corporate")
+
+	network, err := api.CreateAutomaticNetwork(client, env.Id, "net", "10.0.0.0/24", "example.test")
+	require.NoError(t, err)
+	require.NotEmpty(t, network.Id)
+
+	result, err := network.AttachToVpn(client, env.Id, vpn.Id)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Id)
+	require.NoError(t, network.ConnectToVpn(client, env.Id, vpn.Id))
+
+	env = server.Environment(env.Id)
+	require.True(t, env.Networks[0].VpnAttachments[0].Connected)
+
+	_, err = network.AttachToVpn(client, env.Id, "vpn-unknown")
+	require.True(t, api.IsNotFound(err))
+
+	require.NoError(t, network.DetachFromVpn(client, env.Id, vpn.Id))
+	require.NoError(t, api.DeleteNetwork(client, env.Id, network.Id))
+	require.Empty(t, server.Environment(env.Id).Networks)
+}
+
+func TestInterfacesAndServices(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	vm := env.Vms[0]
+
+	nic, err := vm.AddNetworkInterface(client, env.Id, "10.0.0.5", "web", "vmxnet3", false)
+	require.NoError(t, err)
+	require.NotEmpty(t, nic.Id)
+
+	nic, err = nic.AddPublishedService(client, 8080, env.Id, vm.Id)
+	require.NoError(t, err)
+	require.Equal(t, 8080, nic.PublishedServices[0].InternalPort)
+	require.NotEmpty(t, nic.PublishedServices[0].ExternalIp)
+
+	renamed, err := vm.RenameNetworkInterface(client, env.Id, nic.Id, "frontend")
+	require.NoError(t, err)
+	require.Equal(t, "frontend", renamed.Hostname)
+
+	require.NoError(t, vm.RemoveNetworkInterface(client, env.Id, nic.Id))
+	require.Empty(t, server.VirtualMachine(vm.Id).Interfaces)
+}
+
+func TestHardwareAndCredentials(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	template := server.AddTemplate("Golden image", "US-West", "web")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	vm := env.Vms[0]
+
+	vm, err = vm.AddDisk(client, env.Id, 2048, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(vm.Hardware.Disks))
+	require.Equal(t, 2048, *vm.Hardware.Disks[0].Size)
+
+	creds, err := vm.GetCredentials(client)
+	require.NoError(t, err)
+	username, err := creds[0].Username()
+	require.NoError(t, err)
+	require.Equal(t, "root", username)
+}
+
+func TestUnknownRoute(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	_, err := api.GetVirtualMachine(client, "missing")
+	require.True(t, api.IsNotFound(err))
+
+	_, err = api.GetSkytapResource(client, server.URL+"/nothing/here", &struct{}{})
+	require.True(t, api.IsNotFound(err))
+}
+
+func TestListing(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	for i := 0; i < 5; i++ {
+		server.AddVpn("vpn")
+	}
+	var vpns []*api.Vpn
+	err := api.ListVpns(client, &api.ListOptions{PageSize: 2}, func(vpn *api.Vpn) error {
+		vpns = append(vpns, vpn)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(vpns))
+
+	template := server.AddTemplate("Golden image", "US-West", "web", "db", "cache")
+	env, err := api.CreateNewEnvironment(client, template.Id)
+	require.NoError(t, err)
+	var names []string
+	err = api.ListEnvironmentVirtualMachines(client, env.Id, &api.ListOptions{PageSize: 2}, func(vm *api.VirtualMachine) error {
+		names = append(names, vm.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"web", "db", "cache"}, names)
+}
+
+func TestEnvironmentFilters(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	west := server.AddTemplate("West image", "US-West", "web")
+	east := server.AddTemplate("East image", "US-East", "web")
+	_, err := api.CreateNewEnvironment(client, west.Id)
+	require.NoError(t, err)
+	eastEnv, err := api.CreateNewEnvironment(client, east.Id)
+	require.NoError(t, err)
+	_, err = eastEnv.Start(client)
+	require.NoError(t, err)
+
+	list := func(filter *api.EnvironmentFilter) []string {
+		var names []string
+		err := api.ListEnvironments(client, filter, nil, func(env *api.Environment) error {
+			names = append(names, env.Name)
+			return nil
+		})
+		require.NoError(t, err)
+		return names
+	}
+	require.Equal(t, []string{"West image", "East image"}, list(nil))
+	require.Equal(t, []string{"East image"}, list(&api.EnvironmentFilter{Region: "US-East"}))
+	require.Equal(t, []string{"West image"}, list(&api.EnvironmentFilter{Name: "west"}))
+	require.Equal(t, []string{"East image"}, list(&api.EnvironmentFilter{Runstate: api.RunStateStart}))
+}
+
+func TestTemplates(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	server.AddTemplate("Web tier", "US-West", "web", "db")
+	server.AddTemplate("Web tier", "US-East", "web")
+
+	template, err := api.GetTemplateByName(client, "Web tier", api.ScopeMe)
+	require.Error(t, err, "Name is ambiguous across regions")
+
+	var found []*api.Template
+	err = api.ListTemplates(client, &api.TemplateFilter{Name: "web", Region: "US-West"}, nil, func(tmpl *api.Template) error {
+		found = append(found, tmpl)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(found))
+	require.Equal(t, 2, found[0].VmCount)
+
+	template, err = api.GetTemplate(client, found[0].Id)
+	require.NoError(t, err)
+	require.Equal(t, []string{"web", "db"}, []string{template.Vms[0].Name, template.Vms[1].Name})
+	require.NotEmpty(t, template.LockVersion)
+}
+
+func TestCreateTemplateFromEnvironment(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	source := server.AddTemplate("Base", "US-West", "web", "db")
+	env, err := api.CreateNewEnvironment(client, source.Id)
+	require.NoError(t, err)
+
+	template, err := env.SaveAsTemplate(client, []string{env.Vms[1].Id}, "Golden", "nightly build")
+	require.NoError(t, err)
+	require.Equal(t, "Golden", template.Name)
+	require.Equal(t, "nightly build", template.Description)
+	require.Equal(t, "US-West", template.Region)
+	require.Equal(t, 1, len(template.Vms))
+	require.Equal(t, "db", template.Vms[0].Name)
+	require.NotEqual(t, env.Vms[1].Id, template.Vms[0].Id, "Template VMs should be copies")
+}
+
+func TestTemplateBusy(t *testing.T) {
+	server, client := NewTestServer(t, api.WithRetryPolicy(api.NoRetryPolicy{}))
+	server.BusyPolls = 1
+
+	env := server.AddEnvironment("env")
+	created := &api.Template{}
+	_, err := api.RunSkytapRequest(client, true, created, func(s *sling.Sling) *sling.Sling {
+		return s.Post("templates.json").BodyJSON(&api.CreateTemplateBody{EnvironmentId: env.Id})
+	})
+	require.NoError(t, err)
+	require.True(t, created.IsBusy())
+
+	_, err = api.RunSkytapRequest(client, true, nil, func(s *sling.Sling) *sling.Sling {
+		return s.Put("templates/" + created.Id + ".json").BodyJSON(&api.TemplateUpdate{Name: api.String("renamed")})
+	})
+	require.True(t, api.IsBusy(err), "Changes should be rejected while busy")
+
+	template, err := created.WaitUntilReady(client)
+	require.NoError(t, err)
+	require.False(t, template.IsBusy(), "Template should be ready after BusyPolls reads")
+}
+
+func TestTemplateLifecycle(t *testing.T) {
+	server, client := NewTestServer(t)
+
+	base := server.AddTemplate("Base", "US-West", "web")
+	extra := server.AddTemplate("Extra", "US-West", "db", "cache")
+	env, err := api.CreateNewEnvironment(client, extra.Id)
+	require.NoError(t, err)
+
+	template, err := api.GetTemplate(client, base.Id)
+	require.NoError(t, err)
+	template, err = template.MergeEnvironmentVirtualMachines(client, env.Id, []string{env.Vms[0].Id})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(template.Vms))
+	require.Equal(t, "db", template.Vms[1].Name)
+
+	stale := template
+	template, err = template.RemoveVirtualMachine(client, template.Vms[0].Id)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(template.Vms))
+
+	_, err = stale.Update(client, &api.TemplateUpdate{Name: api.String("Clobbered")})
+	require.True(t, api.IsConflict(err), "Updating from a stale representation should conflict")
+
+	template, err = template.Update(client, &api.TemplateUpdate{Name: api.String("Golden"), Public: api.Bool(true)})
+	require.NoError(t, err)
+	require.Equal(t, "Golden", template.Name)
+	require.True(t, template.Public)
+
+	require.NoError(t, template.Delete(client))
+	require.Nil(t, server.Template(template.Id))
+	_, err = api.GetTemplate(client, template.Id)
+	require.True(t, api.IsNotFound(err))
+}